@@ -0,0 +1,146 @@
+package pgo
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// name of the link in the active directory pointing at the active version's directory
+const currentLink = "current"
+
+// names of the links in the active directory, each pointing through the current link
+var activeLinkNames = []string{"bin", "lib", "include", "share"}
+
+// replaceSymlink atomically points link at target, by creating a new link next to it and
+// renaming it over the old one
+func (m *Manager) replaceSymlink(target string, link string) error {
+	tmpLink := fmt.Sprintf("%s.%d", link, os.Getpid())
+	_ = m.fs.Remove(tmpLink)
+	if err := m.fs.Symlink(target, tmpLink); err != nil {
+		return err
+	}
+	if err := m.fs.Rename(tmpLink, link); err != nil {
+		_ = m.fs.Remove(tmpLink)
+		return err
+	}
+	return nil
+}
+
+// getActiveDir returns the directory holding the active links
+func (m *Manager) getActiveDir() string {
+	return filepath.Join(m.cfg.PPrefix, activePath)
+}
+
+// readActiveVersion returns the version the active links point at. The links of prefixes set up
+// before the current link existed point at the version's directories directly.
+func (m *Manager) readActiveVersion() (string, error) {
+	activeDir := m.getActiveDir()
+	target, err := m.fs.Readlink(filepath.Join(activeDir, currentLink))
+	if os.IsNotExist(err) {
+		// legacy layout, bin -> $P_PREFIX/p/versions/python/<version>/bin
+		if target, err = m.fs.Readlink(filepath.Join(activeDir, "bin")); err != nil {
+			return "", err
+		}
+		if filepath.ToSlash(target) == currentLink+"/bin" {
+			return "", ErrNotActive
+		}
+		target = filepath.Dir(target)
+	} else if err != nil {
+		return "", err
+	}
+	return cleanVersionString(filepath.Base(target))
+}
+
+// getActiveVersion returns the globally activated version
+func (m *Manager) getActiveVersion() (string, error) {
+	vstr, err := m.readActiveVersion()
+	if os.IsNotExist(err) {
+		return "", ErrNotActive
+	}
+	return vstr, err
+}
+
+// ensureActiveLinks makes bin, lib, include and share relative links through the current link
+func (m *Manager) ensureActiveLinks() error {
+	activeDir := m.getActiveDir()
+	if err := m.fs.MkdirAll(activeDir, 0755); err != nil {
+		return err
+	}
+	for _, name := range activeLinkNames {
+		link := filepath.Join(activeDir, name)
+		target := filepath.Join(currentLink, name)
+		info, err := m.fs.Lstat(link)
+		if err == nil && info.Mode()&os.ModeSymlink == 0 {
+			return fmt.Errorf("%s is not a link, move it out of the way to activate versions", link)
+		}
+		if existing, err := m.fs.Readlink(link); err == nil && existing == target {
+			continue
+		}
+		if err := m.replaceSymlink(target, link); err != nil {
+			return err
+		}
+		logger.Infof("created link %s --> %s", link, target)
+	}
+	return nil
+}
+
+// needsMigration reports whether the active links still use the legacy layout
+func (m *Manager) needsMigration() bool {
+	activeDir := m.getActiveDir()
+	target, err := m.fs.Readlink(filepath.Join(activeDir, "bin"))
+	return err == nil && filepath.ToSlash(target) != currentLink+"/bin"
+}
+
+// migrateActiveLinks moves a prefix from the legacy layout, where bin, lib, include and share point
+// at the active version directly, to links through the current link. It expects the activation lock to be held.
+func (m *Manager) migrateActiveLinks() error {
+	if !m.needsMigration() {
+		return nil
+	}
+	activeDir := m.getActiveDir()
+	vstr, err := m.readActiveVersion()
+	if err != nil {
+		return err
+	}
+	logger.Infof("migrating active links of %s (version %s)", activeDir, vstr)
+	if err := m.ensureActiveLinks(); err != nil {
+		return err
+	}
+	return m.setCurrentVersion(vstr)
+}
+
+// MigrateActiveLinks updates the active links of the environment's prefix, if an earlier gop set them up
+func MigrateActiveLinks() error {
+	return defaultManager().MigrateActiveLinks()
+}
+
+// MigrateActiveLinks updates the active links of prefixes set up by earlier versions of gop
+func (m *Manager) MigrateActiveLinks() error {
+	if !m.needsMigration() {
+		return nil
+	}
+	release, err := m.acquireLocks(activateLock, prefixLock)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return m.migrateActiveLinks()
+}
+
+// setCurrentVersion atomically points the current link at the version's directory
+func (m *Manager) setCurrentVersion(versionStr string) error {
+	activeDir := m.getActiveDir()
+	versionDir := filepath.Join(m.cfg.PPrefix, versionsPath, versionStr)
+	target, err := filepath.Rel(activeDir, versionDir)
+	if err != nil || strings.HasPrefix(target, "..") {
+		target = versionDir
+	}
+	link := filepath.Join(activeDir, currentLink)
+	if err := m.replaceSymlink(target, link); err != nil {
+		return err
+	}
+	logger.Infof("created link %s --> %s", link, target)
+	return nil
+}
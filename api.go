@@ -1,25 +1,33 @@
 package pgo
 
 import (
+	"errors"
 	"fmt"
-	"io/ioutil"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
-	"github.com/blang/semver"
 	"github.com/juju/loggo"
 )
 
 var (
-	logger              = loggo.GetLogger("pgo")
-	defaultLoggerLevel  = loggo.WARNING
-	ignorePrefixes      = []string{"v", "version", "python/", "Python "}
-	reIdentifier        = regexp.MustCompile(`([0-9]+)\.([0-9]+)\.([0-9]+)`)
-	errNotInstalled     = fmt.Errorf("version not installed")
-	errAlreadyInstalled = fmt.Errorf("version is already installed")
+	logger             = loggo.GetLogger("pgo")
+	defaultLoggerLevel = loggo.WARNING
+	ignorePrefixes     = []string{"v", "version", "python/", "Python "}
+	reIdentifier       = regexp.MustCompile(`([0-9]+)\.([0-9]+)\.([0-9]+)((a|b|rc)[0-9]+)?`)
+)
+
+// errors of the operations on versions, to be checked with errors.Is
+var (
+	// ErrNotInstalled is returned for operations on a version that is not installed
+	ErrNotInstalled = fmt.Errorf("version not installed")
+	// ErrAlreadyInstalled is returned when installing a version that is, without forcing it
+	ErrAlreadyInstalled = fmt.Errorf("version is already installed")
+	// ErrNotActive is returned when no version is active
+	ErrNotActive = fmt.Errorf("no version is active")
 )
 
 const (
@@ -31,6 +39,10 @@ const (
 	activePath = "p/versions"
 	// minimum allowed version
 	minLegalVersion = "2.7.0"
+	// mirror used if P_MIRROR is not set
+	defaultMirror = "https://www.python.org/ftp/python/"
+	// path after prefix of the default release manager keyring
+	keyringPath = "p/keyring.asc"
 )
 
 // Config provides a structure for user configurable parameters
@@ -38,15 +50,69 @@ type Config struct {
 	// PPrefix can be overriden by setting the P_PREFIX environment variable
 	// The default is the $HOME environment variable (%HOME% on Windows).
 	PPrefix string
-	// PMirror can be overriden by setting the P_MIRROR environment variable
+	// PMirrors are the mirrors tried in order until one of them answers. They can be overriden by setting
+	// the P_MIRROR environment variable to a comma separated list, e.g. "https://artifactory.example.com/python/,https://www.python.org/ftp/python/"
 	// The default is "https://www.python.org/ftp/python/"
-	PMirror string
+	PMirrors []string
+	// PMirrorUser and PMirrorPassword are sent as basic auth to the first mirror, PMirrorToken as a bearer token.
+	// They can be set with the P_MIRROR_USER, P_MIRROR_PASSWORD and P_MIRROR_TOKEN environment variables
+	PMirrorUser     string
+	PMirrorPassword string
+	PMirrorToken    string
+	// PNetrc is the netrc file credentials for the other mirrors are read from.
+	// It can be overriden by setting the NETRC environment variable
+	// The default is $HOME/.netrc
+	PNetrc string
+	// PCABundle is a PEM file of certificate authorities trusted in addition to the system ones, e.g. for an internal mirror.
+	// It can be set with the P_CA_BUNDLE environment variable
+	PCABundle string
+	// PKeyring is an armored OpenPGP keyring of release manager keys, used to check the
+	// signatures of downloaded sources. It can be overriden by setting the P_KEYRING environment variable
+	// The default is $P_PREFIX/p/keyring.asc
+	PKeyring string
+	// PChecksums is a path or URL to a SHA-256 checksum manifest ("<sha256>  <filename>" per line)
+	// for the files on the mirror. It can be set with the P_CHECKSUMS environment variable
+	PChecksums string
+	// PSigstoreRoot is a Sigstore trusted root (JSON) used to check the .sigstore bundles of downloaded sources.
+	// It can be set with the P_SIGSTORE_ROOT environment variable
+	// The default is a bundled copy of the trusted root of the Sigstore public-good instance
+	PSigstoreRoot string
+	// PInstallFrom is the default installation method, "source" or "prebuilt".
+	// It can be overriden by setting the P_INSTALL_FROM environment variable
+	// The default is "source"
+	PInstallFrom string
+	// PPrebuiltURL is the URL template of prebuilt distributions, e.g.
+	// "https://example.com/cpython-{version}-{triple}-install_only.tar.gz".
+	// It can be set with the P_PREBUILT_URL environment variable
+	PPrebuiltURL string
+	// PConfigureOpts are extra options passed to ./configure when building from source,
+	// e.g. --enable-optimizations --with-lto. They can be set with the GOP_CONFIGURE_OPTS environment variable
+	PConfigureOpts []string
+	// PMakeOpts are extra options passed to make when building from source.
+	// They can be set with the GOP_MAKE_OPTS environment variable
+	// Unless they set the number of jobs, -j<number of CPUs> is added
+	PMakeOpts []string
+	// PLockTimeout is how long to wait for a lock held by another gop process.
+	// It can be overriden by setting the P_LOCK_TIMEOUT environment variable (e.g. "30s", "5m")
+	// The default is 10 minutes
+	PLockTimeout time.Duration
+	// PIndexTTL is how long the cached version index of the mirror is used before it is downloaded again.
+	// It can be overriden by setting the GOP_INDEX_TTL environment variable (e.g. "1h", "0" to always download)
+	// The default is 24 hours
+	PIndexTTL time.Duration
+	// POffline resolves versions from the cached index and installed versions only, and fails instead of downloading.
+	// It can be set with the GOP_OFFLINE environment variable (e.g. "1")
+	POffline bool
+	// PReleaseStatusURL is the release status table (support phase and end of life of each minor line), in the
+	// format of the Python developer's guide. It can be overriden by setting the P_RELEASE_STATUS_URL environment variable
+	// The default is the table of the developer's guide, and a bundled copy is used when it cannot be downloaded
+	PReleaseStatusURL string
 }
 
 func getConfig() Config {
 	cfg := Config{
-		PPrefix: os.Getenv("HOME"),
-		PMirror: "https://www.python.org/ftp/python/",
+		PPrefix:  os.Getenv("HOME"),
+		PMirrors: []string{defaultMirror},
 	}
 	if os.Getenv("P_PREFIX") != "" {
 		cfg.PPrefix = os.Getenv("P_PREFIX")
@@ -54,15 +120,108 @@ func getConfig() Config {
 	} else {
 		logger.Infof("no P_PREFIX defined, using default: %s", cfg.PPrefix)
 	}
-	if os.Getenv("P_MIRROR") != "" {
-		cfg.PMirror = os.Getenv("P_MIRROR")
-		logger.Debugf("P_MIRROR: %s", cfg.PMirror)
+	if mirrors := splitMirrors(os.Getenv("P_MIRROR")); len(mirrors) > 0 {
+		cfg.PMirrors = mirrors
+		logger.Debugf("P_MIRROR: %s", cfg.PMirrors)
 	} else {
-		logger.Debugf("no P_MIRROR defined, using default: %s", cfg.PMirror)
+		logger.Debugf("no P_MIRROR defined, using default: %s", cfg.PMirrors)
+	}
+	cfg.PMirrorUser = os.Getenv("P_MIRROR_USER")
+	cfg.PMirrorPassword = os.Getenv("P_MIRROR_PASSWORD")
+	cfg.PMirrorToken = os.Getenv("P_MIRROR_TOKEN")
+	cfg.PNetrc = defaultNetrc()
+	if os.Getenv("P_CA_BUNDLE") != "" {
+		cfg.PCABundle = os.Getenv("P_CA_BUNDLE")
+		logger.Debugf("P_CA_BUNDLE: %s", cfg.PCABundle)
+	}
+	cfg.PKeyring = filepath.Join(cfg.PPrefix, keyringPath)
+	if os.Getenv("P_KEYRING") != "" {
+		cfg.PKeyring = os.Getenv("P_KEYRING")
+		logger.Debugf("P_KEYRING: %s", cfg.PKeyring)
+	}
+	if os.Getenv("P_CHECKSUMS") != "" {
+		cfg.PChecksums = os.Getenv("P_CHECKSUMS")
+		logger.Debugf("P_CHECKSUMS: %s", cfg.PChecksums)
+	}
+	if os.Getenv("P_SIGSTORE_ROOT") != "" {
+		cfg.PSigstoreRoot = os.Getenv("P_SIGSTORE_ROOT")
+		logger.Debugf("P_SIGSTORE_ROOT: %s", cfg.PSigstoreRoot)
+	}
+	cfg.PInstallFrom = installFromSource
+	if os.Getenv("P_INSTALL_FROM") != "" {
+		cfg.PInstallFrom = os.Getenv("P_INSTALL_FROM")
+		logger.Debugf("P_INSTALL_FROM: %s", cfg.PInstallFrom)
+	}
+	if os.Getenv("P_PREBUILT_URL") != "" {
+		cfg.PPrebuiltURL = os.Getenv("P_PREBUILT_URL")
+		logger.Debugf("P_PREBUILT_URL: %s", cfg.PPrebuiltURL)
+	}
+	if os.Getenv("GOP_CONFIGURE_OPTS") != "" {
+		cfg.PConfigureOpts = splitOpts(os.Getenv("GOP_CONFIGURE_OPTS"))
+		logger.Debugf("GOP_CONFIGURE_OPTS: %s", cfg.PConfigureOpts)
+	}
+	if os.Getenv("GOP_MAKE_OPTS") != "" {
+		cfg.PMakeOpts = splitOpts(os.Getenv("GOP_MAKE_OPTS"))
+		logger.Debugf("GOP_MAKE_OPTS: %s", cfg.PMakeOpts)
+	}
+	cfg.PLockTimeout = defaultLockTimeout
+	if os.Getenv("P_LOCK_TIMEOUT") != "" {
+		if timeout, err := time.ParseDuration(os.Getenv("P_LOCK_TIMEOUT")); err == nil {
+			cfg.PLockTimeout = timeout
+			logger.Debugf("P_LOCK_TIMEOUT: %s", cfg.PLockTimeout)
+		} else {
+			logger.Warningf("invalid P_LOCK_TIMEOUT, using default %s: %s", cfg.PLockTimeout, err)
+		}
+	}
+	cfg.PReleaseStatusURL = defaultReleaseStatusURL
+	if os.Getenv("P_RELEASE_STATUS_URL") != "" {
+		cfg.PReleaseStatusURL = os.Getenv("P_RELEASE_STATUS_URL")
+		logger.Debugf("P_RELEASE_STATUS_URL: %s", cfg.PReleaseStatusURL)
+	}
+	cfg.PIndexTTL = defaultIndexTTL
+	if os.Getenv("GOP_INDEX_TTL") != "" {
+		if ttl, err := time.ParseDuration(os.Getenv("GOP_INDEX_TTL")); err == nil {
+			cfg.PIndexTTL = ttl
+			logger.Debugf("GOP_INDEX_TTL: %s", cfg.PIndexTTL)
+		} else {
+			logger.Warningf("invalid GOP_INDEX_TTL, using default %s: %s", cfg.PIndexTTL, err)
+		}
+	}
+	if os.Getenv("GOP_OFFLINE") != "" {
+		if offline, err := strconv.ParseBool(os.Getenv("GOP_OFFLINE")); err == nil {
+			cfg.POffline = offline
+			logger.Debugf("GOP_OFFLINE: %t", cfg.POffline)
+		} else {
+			logger.Warningf("invalid GOP_OFFLINE, ignoring it: %s", err)
+		}
 	}
 	return cfg
 }
 
+// InstallOptions provides a structure for parameters of a single installation
+type InstallOptions struct {
+	// Force reinstalls the version if it is already installed
+	Force bool
+	// InsecureSkipVerify installs the downloaded source without checking its checksum or signature
+	InsecureSkipVerify bool
+	// Keyring overrides Config.PKeyring if set
+	Keyring string
+	// Checksums overrides Config.PChecksums if set
+	Checksums string
+	// From overrides Config.PInstallFrom if set
+	From string
+	// ConfigureOpts overrides Config.PConfigureOpts if set
+	ConfigureOpts []string
+	// MakeOpts overrides Config.PMakeOpts if set
+	MakeOpts []string
+	// CFLAGS and LDFLAGS override the CFLAGS and LDFLAGS environment variables for the build if set
+	CFLAGS  string
+	LDFLAGS string
+	// Strict fails the installation if optional modules (ssl, sqlite3, ctypes, ...) cannot be imported,
+	// and before building if their headers are missing
+	Strict bool
+}
+
 // InstallInfo provides a structure for specifying the directories and executable for a given installation.
 // The file paths may or may not exist, but they are always absolute.
 type InstallInfo struct {
@@ -80,24 +239,26 @@ func cleanVersionString(vstr string) (string, error) {
 	}
 	vstr = strings.TrimSpace(vstr)
 
-	// must be X.Y.Z format
+	// must be X.Y.Z format, optionally followed by a pre-release (e.g. X.Y.Zrc1)
 	if !reIdentifier.MatchString(vstr) {
-		return "", fmt.Errorf("version string must be in X.Y.Z format")
+		return "", fmt.Errorf("%w %q: must be in X.Y.Z or X.Y.Z{a|b|rc}N format", ErrInvalidVersion, vstr)
 	}
 
 	return vstr, nil
 }
 
-func isVersionInstalled(versionStr string) (bool, error) {
-	installedVersions, err := GetInstalledVersions()
-	if err != nil {
+func (m *Manager) isVersionInstalled(versionStr string) (bool, error) {
+	installedVersions, err := m.installedVersions()
+	if os.IsNotExist(err) {
+		// nothing has been installed yet
+		return false, nil
+	} else if err != nil {
 		return false, err
 	}
 	return stringContains(installedVersions, versionStr), nil
 }
 
-func getVersionDirectories(versionStr string) InstallInfo {
-	cfg := getConfig()
+func (m *Manager) getVersionDirectories(versionStr string) InstallInfo {
 	dirs := InstallInfo{
 		Executable: "",
 		BinDir:     "",
@@ -106,30 +267,29 @@ func getVersionDirectories(versionStr string) InstallInfo {
 		IncludeDir: "",
 	}
 
-	versionDir := filepath.Join(cfg.PPrefix, versionsPath, versionStr)
-	if _, err := os.Stat(filepath.Join(versionDir, "bin")); err == nil {
+	versionDir := filepath.Join(m.cfg.PPrefix, versionsPath, versionStr)
+	if _, err := m.fs.Stat(filepath.Join(versionDir, "bin")); err == nil {
 		dirs.BinDir = filepath.Join(versionDir, "bin")
 		dirs.Executable = filepath.Join(dirs.BinDir, "python")
 	}
 
-	if _, err := os.Stat(filepath.Join(versionDir, "lib")); err == nil {
+	if _, err := m.fs.Stat(filepath.Join(versionDir, "lib")); err == nil {
 		dirs.LibDir = filepath.Join(versionDir, "lib")
 	}
 
-	if _, err := os.Stat(filepath.Join(versionDir, "share")); err == nil {
+	if _, err := m.fs.Stat(filepath.Join(versionDir, "share")); err == nil {
 		dirs.ShareDir = filepath.Join(versionDir, "share")
 	}
 
-	if _, err := os.Stat(filepath.Join(versionDir, "include")); err == nil {
+	if _, err := m.fs.Stat(filepath.Join(versionDir, "include")); err == nil {
 		dirs.IncludeDir = filepath.Join(versionDir, "include")
 	}
 
 	return dirs
 }
 
-func getActiveDirectories() (InstallInfo, InstallInfo) {
-	cfg := getConfig()
-	activeDir := filepath.Join(cfg.PPrefix, activePath)
+func (m *Manager) getActiveDirectories() (InstallInfo, InstallInfo) {
+	activeDir := filepath.Join(m.cfg.PPrefix, activePath)
 
 	targetDirs := InstallInfo{
 		Executable: filepath.Join(activeDir, "bin", "python"),
@@ -140,68 +300,132 @@ func getActiveDirectories() (InstallInfo, InstallInfo) {
 	}
 	existingDirs := InstallInfo{Executable: "", BinDir: "", LibDir: "", ShareDir: "", IncludeDir: ""}
 
-	if _, err := os.Stat(targetDirs.BinDir); err == nil {
+	if _, err := m.fs.Stat(targetDirs.BinDir); err == nil {
 		existingDirs.BinDir = targetDirs.BinDir
 	}
 
-	if _, err := os.Stat(targetDirs.Executable); err == nil {
+	if _, err := m.fs.Stat(targetDirs.Executable); err == nil {
 		existingDirs.Executable = targetDirs.Executable
 	}
 
-	if _, err := os.Stat(targetDirs.LibDir); err == nil {
+	if _, err := m.fs.Stat(targetDirs.LibDir); err == nil {
 		existingDirs.LibDir = targetDirs.LibDir
 	}
 
-	if _, err := os.Stat(targetDirs.ShareDir); err == nil {
+	if _, err := m.fs.Stat(targetDirs.ShareDir); err == nil {
 		existingDirs.ShareDir = targetDirs.ShareDir
 	}
 
-	if _, err := os.Stat(targetDirs.IncludeDir); err == nil {
+	if _, err := m.fs.Stat(targetDirs.IncludeDir); err == nil {
 		existingDirs.IncludeDir = targetDirs.IncludeDir
 	}
 
 	return existingDirs, targetDirs
 }
 
-// GetCurrentVersion returns the currently active python version
+// GetCurrentVersion returns the version active in the prefix set by P_PREFIX
 func GetCurrentVersion() (string, error) {
-	out, err := exec.Command(excName, "--version").Output()
+	return defaultManager().GetCurrentVersion()
+}
+
+// GetCurrentVersion returns the currently active python version, read from the active links
+// rather than from whichever python comes first on PATH. A session override set with `gop shell`
+// (the GOP_VERSION environment variable) takes precedence. ErrNotActive is returned if no version is active.
+func (m *Manager) GetCurrentVersion() (string, error) {
+	release, err := m.acquireSharedLock(prefixLock)
 	if err != nil {
-		return "", fmt.Errorf("no python found")
+		return "", err
+	}
+	defer release()
+	if env := os.Getenv(versionEnvVar); env != "" {
+		return m.resolveInstalled(env)
 	}
-	return cleanVersionString(string(out))
+	return m.getActiveVersion()
 }
 
-// GetAvailableVersions returns the array of available python versions (from the mirror)
+// GetSystemVersion runs the python on PATH that gop does not manage and returns its version
+func GetSystemVersion() (string, error) {
+	return defaultManager().GetSystemVersion()
+}
+
+// GetSystemVersion returns the version of the python on PATH that is not managed by gop
+func (m *Manager) GetSystemVersion() (string, error) {
+	pythonPath, err := m.findSystemCommand(excName)
+	if err != nil {
+		return "", fmt.Errorf("no system python found")
+	}
+	return m.getPythonBinVersion(pythonPath)
+}
+
+// GetAvailableVersions lists the final releases on the mirrors of P_MIRROR
 func GetAvailableVersions() ([]string, error) {
-	cfg := getConfig()
+	return defaultManager().GetAvailableVersions()
+}
+
+// GetAvailableVersions returns the array of available python versions (from the mirror),
+// oldest first. Pre-releases are left out, see GetAvailableVersionsWithOptions.
+func (m *Manager) GetAvailableVersions() ([]string, error) {
+	return m.GetAvailableVersionsWithOptions(ListOptions{})
+}
+
+// ListOptions provides a structure for parameters of the listings of available versions
+type ListOptions struct {
+	// IncludePre includes pre-releases (alphas, betas and release candidates)
+	IncludePre bool
+}
+
+// GetAvailableVersionsWithOptions returns the available versions like Manager.GetAvailableVersionsWithOptions,
+// with a manager configured from the environment
+func GetAvailableVersionsWithOptions(opts ListOptions) ([]string, error) {
+	return defaultManager().GetAvailableVersionsWithOptions(opts)
+}
+
+// GetAvailableVersionsWithOptions returns the available python versions (from the mirror), oldest first
+func (m *Manager) GetAvailableVersionsWithOptions(opts ListOptions) ([]string, error) {
 
-	minVersion, err := semver.Make(minLegalVersion)
+	minVersion, err := parsePyVersion(minLegalVersion)
 	if err != nil {
 		return nil, err
 	}
 
-	versions, err := getPythonVersions(cfg.PMirror)
+	versions, err := m.getIndex()
 	if err != nil {
 		return nil, err
 	}
 
 	versionStrs := make([]string, 0, len(versions))
-	for _, semver := range versions {
-		if semver.Compare(minVersion) < 0 {
+	for _, pver := range versions {
+		if pver.Compare(minVersion) < 0 {
 			continue
 		}
-		versionStrs = append(versionStrs, semver.String())
+		if pver.IsPrerelease() && !opts.IncludePre {
+			continue
+		}
+		versionStrs = append(versionStrs, pver.String())
 	}
 	return versionStrs, nil
 }
 
-// GetInstalledVersions returns the array of installed python versions
+// GetInstalledVersions lists the versions installed in the prefix set by P_PREFIX
 func GetInstalledVersions() ([]string, error) {
-	cfg := getConfig()
-	versionsDir := filepath.Join(cfg.PPrefix, versionsPath)
+	return defaultManager().GetInstalledVersions()
+}
+
+// GetInstalledVersions returns the array of installed python versions
+func (m *Manager) GetInstalledVersions() ([]string, error) {
+	release, err := m.acquireSharedLock(prefixLock)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return m.installedVersions()
+}
+
+// installedVersions lists the installed versions without taking the prefix lock, for callers holding it
+func (m *Manager) installedVersions() ([]string, error) {
+	versionsDir := filepath.Join(m.cfg.PPrefix, versionsPath)
 	logger.Debugf("versionsDir: %s", versionsDir)
-	versions, err := ioutil.ReadDir(versionsDir)
+	versions, err := m.fs.ReadDir(versionsDir)
 	if err != nil {
 		return nil, err
 	}
@@ -214,7 +438,7 @@ func GetInstalledVersions() ([]string, error) {
 		}
 		// make sure it has python in it
 		// TODO: this will not work on windows (expecting "python.exe")
-		if _, err := os.Stat(filepath.Join(versionsDir, vDir.Name(), "bin", "python")); os.IsNotExist(err) {
+		if _, err := m.fs.Stat(filepath.Join(versionsDir, vDir.Name(), "bin", "python")); os.IsNotExist(err) {
 			continue
 		}
 
@@ -228,185 +452,408 @@ func GetInstalledVersions() ([]string, error) {
 	return installedVersions, nil
 }
 
-// GetLatestVersion returns the latest available version of python
+// GetLatestVersion returns the newest final release on the mirrors of P_MIRROR
 func GetLatestVersion() (string, error) {
-	versions, err := GetAvailableVersions()
+	return defaultManager().GetLatestVersion()
+}
+
+// GetLatestVersion returns the latest available version of python
+func (m *Manager) GetLatestVersion() (string, error) {
+	return m.GetLatestVersionWithOptions(ListOptions{})
+}
+
+// GetLatestVersionWithOptions returns the latest version like Manager.GetLatestVersionWithOptions,
+// with a manager configured from the environment
+func GetLatestVersionWithOptions(opts ListOptions) (string, error) {
+	return defaultManager().GetLatestVersionWithOptions(opts)
+}
+
+// GetLatestVersionWithOptions returns the latest available version of python,
+// which may be a pre-release if opts.IncludePre is set
+func (m *Manager) GetLatestVersionWithOptions(opts ListOptions) (string, error) {
+	versions, err := m.GetAvailableVersionsWithOptions(opts)
 	if err != nil {
 		return "", err
 	}
+	if len(versions) == 0 {
+		return "", fmt.Errorf("no versions available")
+	}
 	return versions[len(versions)-1], nil
 }
 
-// GetStableVersion returns the latest available stable version of python
+// GetStableVersion returns the newest release of a minor line in its bugfix phase, see Manager.GetStableVersion
 func GetStableVersion() (string, error) {
-	versions, err := GetAvailableVersions()
-	if err != nil {
-		return "", err
-	}
+	return defaultManager().GetStableVersion()
+}
 
-	featureSv, err := semver.Make("3.7.0")
+// GetStableVersion returns the latest available release of the newest minor line in its bugfix phase,
+// according to the release status table. Without such a line, it is the latest available release.
+func (m *Manager) GetStableVersion() (string, error) {
+	versions, err := m.GetAvailableVersions()
 	if err != nil {
 		return "", err
 	}
-	stable, versions := versions[len(versions)-1], versions[:len(versions)-1]
-	stableSv, err := semver.Make(stable)
-	if err != nil {
-		return "", err
+	if len(versions) == 0 {
+		return "", fmt.Errorf("no versions available")
 	}
-	// stable is the newest version of 3.6.x
-	for stableSv.Compare(featureSv) >= 0 {
-		stable, versions = versions[len(versions)-1], versions[:len(versions)-1]
-		if stableSv, err = semver.Make(stable); err != nil {
+
+	table := m.getReleaseStatusTable()
+	for idx := len(versions) - 1; idx >= 0; idx-- {
+		pver, err := parsePyVersion(versions[idx])
+		if err != nil {
 			return "", err
 		}
+		if rs, ok := table[minorLine(pver)]; ok && rs.Status == phaseBugfix {
+			return versions[idx], nil
+		}
 	}
-	return stable, nil
+	logger.Warningf("no minor line in its bugfix phase found, using the latest release as stable")
+	return versions[len(versions)-1], nil
 }
 
-// InstallPythonVersion downloads, builds, and activates the desired version of python
+// InstallPythonVersion installs a version in the prefix set by P_PREFIX, with the build options of the environment
 func InstallPythonVersion(versionStr string, force bool) error {
-	if ok, err := isVersionInstalled(versionStr); ok {
-		if force {
-			err = UninstallPythonVersion(versionStr)
-			if err != nil {
-				return err
-			}
-		} else {
-			return errAlreadyInstalled
-		}
-	} else if err != nil {
+	return defaultManager().InstallPythonVersion(versionStr, force)
+}
+
+// InstallPythonVersion downloads, builds, and activates the desired version of python
+func (m *Manager) InstallPythonVersion(versionStr string, force bool) error {
+	return m.InstallPythonVersionWithOptions(versionStr, InstallOptions{Force: force})
+}
+
+// InstallPythonVersionWithOptions installs a version like InstallPythonVersion, with per-installation overrides
+func InstallPythonVersionWithOptions(versionStr string, opts InstallOptions) error {
+	return defaultManager().InstallPythonVersionWithOptions(versionStr, opts)
+}
+
+// InstallPythonVersionWithOptions downloads, verifies, and builds the desired version of python
+func (m *Manager) InstallPythonVersionWithOptions(versionStr string, opts InstallOptions) error {
+	release, err := m.acquireLocks(versionLock(versionStr))
+	if err != nil {
 		return err
 	}
+	defer release()
+
+	if ok, err := m.isVersionInstalled(versionStr); err != nil {
+		return err
+	} else if ok && !opts.Force {
+		return ErrAlreadyInstalled
+	} else if ok {
+		if err := m.uninstallPythonVersion(versionStr); err != nil {
+			return err
+		}
+	}
 
-	cfg := getConfig()
+	// the overrides only apply to this installation
+	cfg := m.cfg
+	if opts.Keyring != "" {
+		cfg.PKeyring = opts.Keyring
+	}
+	if opts.Checksums != "" {
+		cfg.PChecksums = opts.Checksums
+	}
+	m = m.withConfig(cfg).withEventVersion(versionStr)
 
 	// make sure temp directory exists
-	cacheDir := filepath.Join(cfg.PPrefix, versionsPath, "temp")
-	if err := os.MkdirAll(cacheDir, 0700); err != nil && err != os.ErrExist {
+	cacheDir := filepath.Join(m.cfg.PPrefix, versionsPath, "temp")
+	if err := m.fs.MkdirAll(cacheDir, 0700); err != nil && !errors.Is(err, os.ErrExist) {
 		return err
 	}
 
+	from := m.cfg.PInstallFrom
+	if opts.From != "" {
+		from = opts.From
+	}
+	installerURLs := []string{}
+	switch from {
+	case installFromSource:
+		for _, mirror := range m.cfg.PMirrors {
+			installerURLs = append(installerURLs, getPythonInstallerURL(mirror, versionStr))
+		}
+	case installFromPrebuilt:
+		prebuiltURL, err := getPrebuiltURL(m.cfg.PPrebuiltURL, versionStr)
+		if err != nil {
+			return err
+		}
+		installerURLs = append(installerURLs, prebuiltURL)
+	default:
+		return fmt.Errorf("unknown installation method %q, must be %q or %q", from, installFromSource, installFromPrebuilt)
+	}
+
 	// download the installation to that directory
-	installer, err := getPythonInstaller(cfg.PMirror, versionStr, cacheDir)
+	installer, installerURL, err := m.getPythonInstaller(installerURLs, cacheDir)
 	if err != nil {
 		return err
 	}
 	logger.Debugf("installer saved at %s", installer)
 
-	// make version's directory and install
-	versionDir := filepath.Join(cfg.PPrefix, versionsPath, versionStr)
-	if err := os.MkdirAll(cacheDir, 0700); err != nil && err != os.ErrExist {
+	// check it is what the mirror published before building anything from it
+	if opts.InsecureSkipVerify {
+		logger.Warningf("skipping verification of %s", installer)
+	} else {
+		m.emit(Event{Type: EventVerifying, Message: installer})
+		if err := m.verifyPythonInstaller(installerURL, installer, versionStr, from == installFromSource); err != nil {
+			logger.Infof("verification of %s failed, deleting it...", installer)
+			_ = m.removeInstaller(installer)
+			return err
+		}
+	}
+
+	// build in a staging directory, and only move the installation into place once it checks out
+	versionDir := filepath.Join(m.cfg.PPrefix, versionsPath, versionStr)
+	stagingDir, err := m.newStagingDir(versionStr)
+	if err != nil {
 		return err
 	}
-	if _, err := installPythonInstaller(installer, versionDir); err != nil {
-		logger.Infof("error installing %s, deleting directory...", installer)
-		_ = os.RemoveAll(versionDir)
+	// also removed when the context is done, as the build is killed and its error returned
+	defer m.fs.RemoveAll(stagingDir)
+
+	info := BuildInfo{
+		Version: versionStr,
+		Method:  from,
+		Source:  redactURL(installerURL),
+	}
+	install := m.installPrebuilt
+	if from == installFromSource {
+		install = m.installPythonInstaller
+		info.CFLAGS = os.Getenv("CFLAGS")
+		info.LDFLAGS = os.Getenv("LDFLAGS")
+		info.ConfigureOpts = m.cfg.PConfigureOpts
+		if opts.ConfigureOpts != nil {
+			info.ConfigureOpts = opts.ConfigureOpts
+		}
+		info.MakeOpts = m.cfg.PMakeOpts
+		if opts.MakeOpts != nil {
+			info.MakeOpts = opts.MakeOpts
+		}
+		info.MakeOpts = withDefaultJobs(info.MakeOpts)
+		if opts.CFLAGS != "" {
+			info.CFLAGS = opts.CFLAGS
+		}
+		if opts.LDFLAGS != "" {
+			info.LDFLAGS = opts.LDFLAGS
+		}
+		if info.LogFile, err = m.newBuildLogPath(versionStr); err != nil {
+			return err
+		}
+		if err := m.preflightBuild(info, opts.Strict); err != nil {
+			return err
+		}
+	}
+	stagedDir, err := install(installer, versionDir, stagingDir, info)
+	if err != nil {
+		return err
+	}
+	pythonPath, err := m.makePythonLinks(stagedDir)
+	if err != nil {
+		return err
+	}
+	m.emit(Event{Type: EventChecking, Message: pythonPath})
+	if err := m.checkPythonBinVersion(pythonPath, versionStr); err != nil {
 		return err
 	}
 
+	// make sure the optional parts of the standard library were built
+	if info.MissingModules, err = m.checkOptionalModules(pythonPath, versionStr); err != nil {
+		return err
+	}
+	if len(info.MissingModules) > 0 {
+		if opts.Strict {
+			return fmt.Errorf("python %s was built without modules: %s", versionStr, strings.Join(info.MissingModules, ", "))
+		}
+		logger.Warningf("python %s was built without modules: %s", versionStr, strings.Join(info.MissingModules, ", "))
+	}
+	info.InstalledAt = time.Now()
+	if err := m.writeBuildInfo(stagedDir, info); err != nil {
+		return err
+	}
+
+	// move it into place, replacing what is left of an incomplete installation
+	if err := m.moveIntoPlace(stagedDir, versionDir); err != nil {
+		return err
+	}
+	m.emit(Event{Type: EventInstalled, Message: versionDir})
+
 	// and remove installer
-	if err := os.Remove(installer); err != nil {
+	if err := m.removeInstaller(installer); err != nil {
 		return err
 	}
 
-	return nil
+	return m.rehashIfEnabled()
 }
 
-// UninstallPythonVersion uninstalls the specified version of python
+// moveIntoPlace renames the staged installation to versionDir, holding the prefix lock
+// so that the version does not appear halfway to processes listing the installed versions
+func (m *Manager) moveIntoPlace(stagedDir string, versionDir string) error {
+	release, err := m.acquireLocks(prefixLock)
+	if err != nil {
+		return err
+	}
+	defer release()
+	if err := m.fs.RemoveAll(versionDir); err != nil {
+		return err
+	}
+	if err := m.fs.MkdirAll(filepath.Dir(versionDir), 0755); err != nil {
+		return err
+	}
+	return m.fs.Rename(stagedDir, versionDir)
+}
+
+// UninstallPythonVersion removes a version from the prefix set by P_PREFIX
 func UninstallPythonVersion(versionStr string) error {
-	if ok, err := isVersionInstalled(versionStr); !ok {
-		return errNotInstalled
-	} else if err != nil {
+	return defaultManager().UninstallPythonVersion(versionStr)
+}
+
+// UninstallPythonVersion uninstalls the specified version of python
+func (m *Manager) UninstallPythonVersion(versionStr string) error {
+	release, err := m.acquireLocks(versionLock(versionStr))
+	if err != nil {
 		return err
 	}
+	defer release()
+	return m.uninstallPythonVersion(versionStr)
+}
+
+// uninstallPythonVersion uninstalls the version, expecting its lock to be held
+func (m *Manager) uninstallPythonVersion(versionStr string) error {
+	if err := m.removePythonVersion(versionStr); err != nil {
+		return err
+	}
+	return m.rehashIfEnabled()
+}
 
-	current, err := GetCurrentVersion()
+// removePythonVersion deactivates the version if it is the active one and removes it. It holds the
+// activation and prefix locks throughout, so that the version cannot be activated or be seen halfway
+// removed while it is being removed.
+func (m *Manager) removePythonVersion(versionStr string) error {
+	release, err := m.acquireLocks(activateLock, prefixLock)
 	if err != nil {
 		return err
 	}
+	defer release()
+
+	if ok, err := m.isVersionInstalled(versionStr); err != nil {
+		return err
+	} else if !ok {
+		return ErrNotInstalled
+	}
+
+	current, err := m.getActiveVersion()
+	if err != nil && !errors.Is(err, ErrNotActive) {
+		return err
+	}
 	if current == versionStr {
 		logger.Warningf("version %s is active, deactivating...", versionStr)
-		if err = Deactivate(); err != nil {
+		if err = m.deactivate(); err != nil {
 			return err
 		}
 	}
 
-	cfg := getConfig()
-	versionDir := filepath.Join(cfg.PPrefix, versionsPath, versionStr)
-	logger.Infof("deleting %s", versionDir)
-	if err := os.RemoveAll(versionDir); err != nil {
+	versionDir := filepath.Join(m.cfg.PPrefix, versionsPath, versionStr)
+	m.emit(Event{Type: EventUninstalling, Version: versionStr, Message: versionDir})
+	if err := m.fs.RemoveAll(versionDir); err != nil {
 		return err
 	}
-
+	m.emit(Event{Type: EventUninstalled, Version: versionStr})
 	return nil
 }
 
-// ActivatePythonVersion creates links to the specified version in the active directories
+// ActivatePythonVersion makes an installed version the active one of the prefix set by P_PREFIX
 func ActivatePythonVersion(versionStr string) error {
-	if ok, err := isVersionInstalled(versionStr); !ok {
-		return errNotInstalled
-	} else if err != nil {
+	return defaultManager().ActivatePythonVersion(versionStr)
+}
+
+// ActivatePythonVersion points the active links at the specified version
+func (m *Manager) ActivatePythonVersion(versionStr string) error {
+	release, err := m.acquireLocks(activateLock, prefixLock)
+	if err != nil {
 		return err
 	}
+	defer release()
 
-	// remove any active directories
-	if err := Deactivate(); err != nil {
+	if ok, err := m.isVersionInstalled(versionStr); err != nil {
 		return err
+	} else if !ok {
+		return ErrNotInstalled
 	}
 
-	// make the links
-	_, activeTarget := getActiveDirectories()
-	versionDirs := getVersionDirectories(versionStr)
-	sources := []string{versionDirs.BinDir, versionDirs.LibDir, versionDirs.IncludeDir, versionDirs.ShareDir}
-	targets := []string{activeTarget.BinDir, activeTarget.LibDir, activeTarget.IncludeDir, activeTarget.ShareDir}
-	for idx := range sources {
-		if err := os.Symlink(sources[idx], targets[idx]); err != nil {
-			return err
-		}
-		logger.Infof("created link %s --> %s", sources[idx], targets[idx])
+	m.emit(Event{Type: EventActivating, Version: versionStr})
+	// bin, lib, include and share link through current, so switching it switches them all at once
+	if err := m.migrateActiveLinks(); err != nil {
+		return err
 	}
-
+	if err := m.ensureActiveLinks(); err != nil {
+		return err
+	}
+	if err := m.setCurrentVersion(versionStr); err != nil {
+		return err
+	}
+	m.emit(Event{Type: EventActivated, Version: versionStr, Message: m.getActiveDir()})
+	m.warnIfEndOfLife(versionStr)
 	return nil
 }
 
-// Deactivate removes links for the currently active version
+// Deactivate unlinks the active version of the prefix set by P_PREFIX
 func Deactivate() error {
-	activeExisting, _ := getActiveDirectories()
-	for _, dir := range []string{activeExisting.BinDir, activeExisting.LibDir, activeExisting.IncludeDir, activeExisting.ShareDir} {
-		if dir != "" {
-			// it exists, remove it
-			if err := os.RemoveAll(dir); err != nil {
-				return err
-			}
-			logger.Infof("removed %s", dir)
-		}
+	return defaultManager().Deactivate()
+}
+
+// Deactivate unlinks the currently active version
+func (m *Manager) Deactivate() error {
+	release, err := m.acquireLocks(activateLock, prefixLock)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return m.deactivate()
+}
+
+// deactivate removes the current link, expecting the activation lock to be held.
+// The links through it are left dangling, so the system python is found on PATH instead.
+func (m *Manager) deactivate() error {
+	if err := m.migrateActiveLinks(); err != nil {
+		return err
 	}
+	link := filepath.Join(m.getActiveDir(), currentLink)
+	if err := m.fs.Remove(link); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	m.emit(Event{Type: EventDeactivated, Message: link})
 	return nil
 }
 
-// VersionFiles returns the installation information for the given version
+// VersionFiles returns where a version of the environment's prefix keeps its files
 func VersionFiles(versionStr string) (*InstallInfo, error) {
-	if ok, err := isVersionInstalled(versionStr); !ok {
-		return nil, errNotInstalled
-	} else if err != nil {
+	return defaultManager().VersionFiles(versionStr)
+}
+
+// VersionFiles returns the installation information for the given version
+func (m *Manager) VersionFiles(versionStr string) (*InstallInfo, error) {
+	if ok, err := m.isVersionInstalled(versionStr); err != nil {
 		return nil, err
+	} else if !ok {
+		return nil, ErrNotInstalled
 	}
 
-	files := getVersionDirectories(versionStr)
+	files := m.getVersionDirectories(versionStr)
 	return &files, nil
 }
 
-// CallWithVersion executes the args with the specified python version
+// CallWithVersion runs the python of an installed version with args
 func CallWithVersion(versionStr string, args []string) error {
-	files, err := VersionFiles(versionStr)
+	return defaultManager().CallWithVersion(versionStr, args)
+}
+
+// CallWithVersion executes the args with the specified python version
+func (m *Manager) CallWithVersion(versionStr string, args []string) error {
+	files, err := m.VersionFiles(versionStr)
 	if err != nil {
 		return err
 	}
 	args = append([]string{"-c"}, args...)
-	cmd := exec.Command(files.Executable, args...)
 	logger.Infof("cmd: %s", files.Executable)
 	logger.Infof("args: %s", args)
-	out, err := cmd.CombinedOutput()
+	out, err := m.output(Command{Name: files.Executable, Args: args}, true)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,116 @@
+package pgo
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// name of the file in each version's directory recording how it was installed
+const buildInfoFile = "gop-build.json"
+
+// BuildInfo records how a version was installed. It is saved in the version's directory.
+type BuildInfo struct {
+	Version string `json:"version"`
+	// Method is "source" or "prebuilt"
+	Method string `json:"method"`
+	// Source is the URL the installer was downloaded from
+	Source        string   `json:"source"`
+	ConfigureOpts []string `json:"configure_opts,omitempty"`
+	MakeOpts      []string `json:"make_opts,omitempty"`
+	CFLAGS        string   `json:"cflags,omitempty"`
+	LDFLAGS       string   `json:"ldflags,omitempty"`
+	// LogFile is the log of the configure and make output
+	LogFile string `json:"log_file,omitempty"`
+	// MissingModules are the optional standard library modules that cannot be imported
+	MissingModules []string  `json:"missing_modules,omitempty"`
+	InstalledAt    time.Time `json:"installed_at"`
+}
+
+// splitOpts splits a whitespace separated list of options, as given in GOP_CONFIGURE_OPTS
+func splitOpts(opts string) []string {
+	return strings.Fields(opts)
+}
+
+// isJobsOpt reports whether a make option sets the number of jobs
+func isJobsOpt(opt string) bool {
+	return strings.HasPrefix(opt, "-j") || strings.HasPrefix(opt, "--jobs")
+}
+
+// withDefaultJobs adds -j<number of CPUs> to make options that do not set the number of jobs
+func withDefaultJobs(makeOpts []string) []string {
+	for _, opt := range makeOpts {
+		if isJobsOpt(opt) {
+			return makeOpts
+		}
+	}
+	return append([]string{fmt.Sprintf("-j%d", runtime.NumCPU())}, makeOpts...)
+}
+
+// withJobs replaces the options setting the number of jobs (-j4, -j 4, --jobs=4, --jobs 4) with -j<jobs>
+func withJobs(makeOpts []string, jobs int) []string {
+	result := make([]string, 0, len(makeOpts)+1)
+	for i := 0; i < len(makeOpts); i++ {
+		opt := makeOpts[i]
+		if !isJobsOpt(opt) {
+			result = append(result, opt)
+			continue
+		}
+		if (opt == "-j" || opt == "--jobs") && i+1 < len(makeOpts) {
+			if _, err := strconv.Atoi(makeOpts[i+1]); err == nil {
+				i++
+			}
+		}
+	}
+	return append(result, fmt.Sprintf("-j%d", jobs))
+}
+
+// env returns the environment to run the build in, passing CFLAGS and LDFLAGS through
+func (info BuildInfo) env() []string {
+	env := os.Environ()
+	if info.CFLAGS != "" {
+		env = append(env, "CFLAGS="+info.CFLAGS)
+	}
+	if info.LDFLAGS != "" {
+		env = append(env, "LDFLAGS="+info.LDFLAGS)
+	}
+	return env
+}
+
+func (m *Manager) writeBuildInfo(versionDir string, info BuildInfo) error {
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+	return m.fs.WriteFile(filepath.Join(versionDir, buildInfoFile), append(data, '\n'), 0644)
+}
+
+// GetBuildInfo reads the build record of a version installed in the prefix set by P_PREFIX
+func GetBuildInfo(versionStr string) (*BuildInfo, error) {
+	return defaultManager().GetBuildInfo(versionStr)
+}
+
+// GetBuildInfo returns how the given installed version was built.
+// Versions installed before build information was recorded return os.ErrNotExist.
+func (m *Manager) GetBuildInfo(versionStr string) (*BuildInfo, error) {
+	if ok, err := m.isVersionInstalled(versionStr); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, ErrNotInstalled
+	}
+
+	data, err := m.fs.ReadFile(filepath.Join(m.cfg.PPrefix, versionsPath, versionStr, buildInfoFile))
+	if err != nil {
+		return nil, err
+	}
+	info := &BuildInfo{}
+	if err := json.Unmarshal(data, info); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
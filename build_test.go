@@ -0,0 +1,52 @@
+package pgo
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestWithJobs(t *testing.T) {
+	tests := []struct {
+		makeOpts []string
+		want     []string
+	}{
+		{nil, []string{"-j3"}},
+		{[]string{"-j8"}, []string{"-j3"}},
+		{[]string{"-j", "8", "V=1"}, []string{"V=1", "-j3"}},
+		{[]string{"--jobs=8", "-k"}, []string{"-k", "-j3"}},
+		{[]string{"--jobs", "8"}, []string{"-j3"}},
+		{[]string{"-j", "V=1"}, []string{"V=1", "-j3"}},
+	}
+	for _, test := range tests {
+		if got := withJobs(test.makeOpts, 3); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("withJobs(%q, 3) = %q, want %q", test.makeOpts, got, test.want)
+		}
+	}
+}
+
+func TestGetBuildInfoReportsErrors(t *testing.T) {
+	prefix := t.TempDir()
+	m := NewManager(Config{PPrefix: prefix}, ManagerOptions{})
+	if _, err := m.GetBuildInfo("3.12.1"); !errors.Is(err, ErrNotInstalled) {
+		t.Errorf("GetBuildInfo without versions = %v, want ErrNotInstalled", err)
+	}
+
+	// the versions directory cannot be read
+	versionsDir := filepath.Join(prefix, versionsPath)
+	if err := os.MkdirAll(filepath.Dir(versionsDir), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(versionsDir, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.GetBuildInfo("3.12.1"); err == nil || errors.Is(err, ErrNotInstalled) {
+		t.Errorf("GetBuildInfo with an unreadable versions directory = %v, want the error reading it", err)
+	}
+	if err := m.ActivatePythonVersion("3.12.1"); err == nil || errors.Is(err, ErrNotInstalled) {
+		t.Errorf("ActivatePythonVersion with an unreadable versions directory = %v, want the error reading it", err)
+	}
+}
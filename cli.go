@@ -1,12 +1,206 @@
 package pgo
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"text/template"
+	"time"
 
 	"github.com/juju/loggo"
 	"github.com/urfave/cli"
 )
 
+// installFlags are the options of a single installation, shared by install and upgrade
+var installFlags = []cli.Flag{
+	cli.BoolFlag{Name: "insecure-skip-verify", Usage: "do not verify the downloaded source"},
+	cli.StringFlag{Name: "keyring", Usage: "armored OpenPGP keyring to check signatures with"},
+	cli.StringFlag{Name: "checksums", Usage: "path or URL of a SHA-256 checksum manifest"},
+	cli.BoolFlag{Name: "prebuilt", Usage: "install a prebuilt distribution from P_PREBUILT_URL"},
+	cli.BoolFlag{Name: "from-source", Usage: "build from the source tarball on the mirror"},
+	cli.StringFlag{Name: "configure-opts", Usage: "options for ./configure, overriding GOP_CONFIGURE_OPTS"},
+	cli.StringFlag{Name: "make-opts", Usage: "options for make, overriding GOP_MAKE_OPTS"},
+	cli.IntFlag{Name: "jobs, j", Usage: "number of parallel make jobs (default: number of CPUs)"},
+	cli.StringFlag{Name: "cflags", Usage: "CFLAGS for the build"},
+	cli.StringFlag{Name: "ldflags", Usage: "LDFLAGS for the build"},
+	cli.BoolFlag{Name: "strict", Usage: "fail if optional modules (ssl, sqlite3, ctypes, ...) are missing"},
+}
+
+// exit statuses of the gop command, see ExitCode
+const (
+	ExitFailure         = 1
+	ExitInvalidVersion  = 2
+	ExitVersionNotFound = 3
+	ExitNotInstalled    = 4
+	ExitDownload        = 5
+	ExitOffline         = 6
+	ExitVerification    = 7
+	ExitBuild           = 8
+	ExitLockTimeout     = 9
+	// as timeout(1) and shells do
+	ExitTimeout     = 124
+	ExitInterrupted = 130
+)
+
+// ExitCode returns the exit status of the gop command for an error returned by the app.
+// Errors matching none of the documented ones give ExitFailure. An error matching several
+// gets the first of them in the order of the cases below: a checksum or signature that could
+// not be downloaded is a download error, whatever verification error it is wrapped in.
+func ExitCode(err error) int {
+	var notFound *VersionNotFoundError
+	var download *DownloadError
+	var build *BuildError
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, context.DeadlineExceeded):
+		return ExitTimeout
+	case errors.Is(err, context.Canceled):
+		return ExitInterrupted
+	case errors.Is(err, ErrInvalidVersion):
+		return ExitInvalidVersion
+	case errors.As(err, &notFound):
+		return ExitVersionNotFound
+	case errors.Is(err, ErrNotInstalled):
+		return ExitNotInstalled
+	case errors.Is(err, ErrOffline):
+		return ExitOffline
+	case errors.As(err, &download):
+		return ExitDownload
+	case errors.Is(err, ErrVerificationFailed), errors.Is(err, ErrNotVerified):
+		return ExitVerification
+	case errors.As(err, &build):
+		return ExitBuild
+	case errors.Is(err, ErrLockTimeout):
+		return ExitLockTimeout
+	}
+	return ExitFailure
+}
+
+// key of the manager of the running command in the app metadata
+const managerKey = "manager"
+
+// value of --events printing each event as a line of JSON
+const eventsJSON = "json"
+
+// startContext returns the context of the running command. It is done when --timeout expires,
+// or on the first Ctrl-C, SIGTERM or SIGHUP, which stops downloads and kills builds so that they are
+// cleaned up. Builds run in their own process group, out of reach of the terminal, so a second signal
+// kills the groups still running before exiting right away.
+func startContext(c *cli.Context) (context.Context, context.CancelFunc) {
+	ctx := context.Background()
+	cancelTimeout := func() {}
+	if timeout := c.Duration("timeout"); timeout > 0 {
+		ctx, cancelTimeout = context.WithTimeout(ctx, timeout)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	stop := make(chan struct{})
+	go func() {
+		defer signal.Stop(signals)
+		interrupted := false
+		for {
+			select {
+			case sig := <-signals:
+				if interrupted {
+					logger.Warningf("received %s again, exiting", sig)
+					killRunningCommands()
+					os.Exit(ExitInterrupted)
+				}
+				logger.Warningf("received %s, stopping...", sig)
+				interrupted = true
+				cancel()
+			case <-stop:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return ctx, func() {
+		once.Do(func() { close(stop) })
+		cancel()
+		cancelTimeout()
+	}
+}
+
+// cliManager returns the manager of the running command: configured from the environment and the
+// global options, reporting to the events handler and stopping with the context of the command
+func cliManager(c *cli.Context) *Manager {
+	if m, ok := c.App.Metadata[managerKey].(*Manager); ok {
+		return m
+	}
+	return defaultManager()
+}
+
+// cliEvents shows the events of the running command on stderr: as lines of JSON with --events=json,
+// otherwise as a progress bar for downloads (on a terminal) and log messages for the other steps
+type cliEvents struct {
+	json bool
+	bar  *progressBar
+}
+
+// newCLIEvents returns the handler for the given --events format
+func newCLIEvents(format string) (*cliEvents, error) {
+	switch format {
+	case "":
+		return &cliEvents{}, nil
+	case eventsJSON:
+		return &cliEvents{json: true}, nil
+	}
+	return nil, fmt.Errorf("unknown events format %q, must be %q", format, eventsJSON)
+}
+
+func (h *cliEvents) HandleEvent(e Event) {
+	if h.json {
+		data, err := json.Marshal(e)
+		if err != nil {
+			logger.Warningf("unable to encode event %s: %s", e, err)
+			return
+		}
+		fmt.Fprintln(os.Stderr, string(data))
+		return
+	}
+
+	switch e.Type {
+	case EventDownloading:
+		h.finish()
+		if isTerminal(os.Stderr) {
+			h.bar = newProgressBar(path.Base(e.URL), e.Bytes, e.Total)
+		}
+	case EventProgress:
+		if h.bar != nil {
+			h.bar.update(e.Bytes)
+		}
+		return
+	case EventDownloaded:
+		if h.bar != nil {
+			h.bar.current = e.Bytes
+		}
+		h.finish()
+	default:
+		h.finish()
+	}
+	logger.Infof("%s", e)
+}
+
+// finish ends the progress bar of the last download, if it is drawn
+func (h *cliEvents) finish() {
+	if h.bar != nil {
+		h.bar.finish()
+		h.bar = nil
+	}
+}
+
 // MakeApp constructs a configured CLI application
 func MakeApp() *cli.App {
 	logger.SetLogLevel(defaultLoggerLevel)
@@ -14,27 +208,82 @@ func MakeApp() *cli.App {
 	app.Name = "gop"
 	app.Usage = "simple governing of your Python versions"
 	app.Version = "0.0.1"
+	cancel := func() {}
+	events := &cliEvents{}
 	app.Before = func(c *cli.Context) error {
-		cfg := getConfig()
-		if err := checkConfiguration(cfg); err != nil {
+		var ctx context.Context
+		ctx, cancel = startContext(c)
+
+		if err := defaultManager().checkConfiguration(); err != nil {
+			return err
+		}
+		handler, err := newCLIEvents(c.String("events"))
+		if err != nil {
 			return err
 		}
+		events = handler
+		if c.Bool("json") && c.String("format") != "" {
+			return fmt.Errorf("--json and --format are mutually exclusive")
+		}
 
 		if c.Bool("verbose") {
 			logger.SetLogLevel(loggo.INFO)
 		}
+
+		// getConfig reads offline mode from the environment
+		if c.Bool("offline") {
+			if err := os.Setenv("GOP_OFFLINE", "1"); err != nil {
+				return err
+			}
+		}
+		m := NewManager(getConfig(), ManagerOptions{Events: events}).WithContext(ctx)
+		c.App.Metadata[managerKey] = m
+
+		if c.Bool("refresh") {
+			if err := m.RefreshIndex(); err != nil {
+				return err
+			}
+			if err := m.RefreshReleaseStatus(); err != nil {
+				logger.Warningf("unable to download the release status table: %s", err)
+			}
+		}
+
+		// move prefixes set up by earlier versions to the current link
+		if err := m.MigrateActiveLinks(); err != nil {
+			logger.Warningf("unable to migrate active links: %s", err)
+		}
+
+		// remove what interrupted installations left behind
+		if err := m.CleanStaging(); err != nil {
+			logger.Warningf("unable to clean staging directories: %s", err)
+		}
+		return nil
+	}
+	app.After = func(c *cli.Context) error {
+		events.finish()
+		cancel()
 		return nil
 	}
 	app.Action = ActivateVersion
 	app.Flags = []cli.Flag{
 		cli.BoolFlag{Name: "verbose"},
+		cli.BoolFlag{Name: "json", Usage: "output ls, status, bin and info as JSON documents"},
+		cli.StringFlag{Name: "format", Usage: "output ls, status, bin and info with this Go template, e.g. '{{range .Versions}}{{.Version}} {{end}}'"},
+		cli.StringFlag{Name: "events", Usage: "print the steps of installations and activations on stderr in this format: json"},
+		cli.DurationFlag{Name: "timeout", Usage: "give up on downloads and builds after this long (e.g. 30m)"},
+		cli.BoolFlag{Name: "refresh", Usage: "download the version index of the mirror instead of using the cached one"},
+		cli.BoolFlag{Name: "offline", Usage: "use the cached version index and installed versions only, never download", EnvVar: "GOP_OFFLINE"},
 	}
 	app.Commands = []cli.Command{
 		{
 			Name:    "ls",
 			Aliases: []string{"list"},
 			Usage:   "Output the versions of Python available",
-			Action:  ListAvailable,
+			Flags: []cli.Flag{
+				cli.BoolFlag{Name: "pre", Usage: "include pre-releases (alpha, beta, rc)"},
+				cli.BoolFlag{Name: "status", Usage: "output the support phase and end of life of each minor version"},
+			},
+			Action: ListAvailable,
 			Subcommands: []cli.Command{
 				{
 					Name:     "installed",
@@ -46,7 +295,10 @@ func MakeApp() *cli.App {
 					Name:     "latest",
 					HelpName: "ls latest",
 					Usage:    "Output the latest Python version available",
-					Action:   ShowLatest,
+					Flags: []cli.Flag{
+						cli.BoolFlag{Name: "pre", Usage: "include pre-releases (alpha, beta, rc)"},
+					},
+					Action: ShowLatest,
 				},
 				{
 					Name:     "stable",
@@ -57,8 +309,12 @@ func MakeApp() *cli.App {
 			},
 		},
 		{
-			Name:   "latest",
-			Usage:  "Activate to the latest Python release",
+			Name:      "latest",
+			Usage:     "Activate to the latest Python release",
+			ArgsUsage: "--pre",
+			Flags: []cli.Flag{
+				cli.BoolFlag{Name: "pre", Usage: "include pre-releases (alpha, beta, rc)"},
+			},
 			Action: ActivateLatest,
 		},
 		{
@@ -71,14 +327,37 @@ func MakeApp() *cli.App {
 			Usage:  "Output current status",
 			Action: ShowStatus,
 		},
+		{
+			Name:      "local",
+			Usage:     "Pin <version ...> for this directory in " + localVersionFile,
+			ArgsUsage: "<version ...> --unset",
+			Flags: []cli.Flag{
+				cli.BoolFlag{Name: "unset", Usage: "remove the " + localVersionFile + " file"},
+			},
+			Action: SetLocalVersion,
+		},
 		{
 			Name:      "install",
 			Usage:     "Install Python <version> but do NOT activate",
 			ArgsUsage: "<version> --force",
-			Flags: []cli.Flag{
-				cli.BoolFlag{Name: "force"},
-			},
-			Action: InstallVersion,
+			Flags:     append([]cli.Flag{cli.BoolFlag{Name: "force"}}, installFlags...),
+			Action:    InstallVersion,
+		},
+		{
+			Name:      "upgrade",
+			Usage:     "Install the newest patch release of each installed minor version, or of [version]",
+			ArgsUsage: "[version] --prune --update-local",
+			Flags: append([]cli.Flag{
+				cli.BoolFlag{Name: "prune", Usage: "remove the patch releases superseded by the new ones"},
+				cli.BoolFlag{Name: "update-local", Usage: "replace the old version in the nearest .python-version file"},
+			}, installFlags...),
+			Action: UpgradeVersions,
+		},
+		{
+			Name:      "outdated",
+			Usage:     "Output the installed minor versions with a newer patch release, or only [version]",
+			ArgsUsage: "[version]",
+			Action:    ShowOutdated,
 		},
 		{
 			Name:      "use",
@@ -92,12 +371,84 @@ func MakeApp() *cli.App {
 			ArgsUsage: "<version>",
 			Action:    ShowVersion,
 		},
+		{
+			Name:      "info",
+			Usage:     "Output how <version> was installed",
+			ArgsUsage: "<version>",
+			Action:    ShowInfo,
+		},
+		{
+			Name:      "logs",
+			Usage:     "Output the latest build log for [version], or list all build logs",
+			ArgsUsage: "[version]",
+			Action:    ShowLogs,
+		},
 		{
 			Name:      "rm",
 			Usage:     "Remove the given version(s)",
 			ArgsUsage: "<version ...>",
 			Action:    RemoveVersion,
 		},
+		{
+			Name:   "rehash",
+			Usage:  "Regenerate the shims for all installed executables",
+			Action: RehashShims,
+		},
+		{
+			Name:  "mirror",
+			Usage: "Manage local mirrors for hosts without internet access",
+			Subcommands: []cli.Command{
+				{
+					Name:      "sync",
+					HelpName:  "mirror sync",
+					Usage:     "Download the sources of --versions into <dir>, laid out like python.org",
+					ArgsUsage: "<dir>",
+					Flags: []cli.Flag{
+						cli.StringFlag{Name: "versions", Usage: "versions to sync, e.g. 3.9-3.12 or \">=3.10\""},
+					},
+					Action: SyncMirrorDir,
+				},
+			},
+		},
+		{
+			Name:            "exec",
+			Usage:           "Run <command> from the version selected for this directory",
+			ArgsUsage:       "<command> [args ...]",
+			SkipFlagParsing: true,
+			Action:          ExecShim,
+		},
+		{
+			Name:            ShimCommand,
+			Usage:           "Run a shim, see RunShim",
+			Hidden:          true,
+			SkipFlagParsing: true,
+			Action: func(c *cli.Context) error {
+				return RunShim(c.Args())
+			},
+		},
+		{
+			Name:      "init",
+			Usage:     "Output the shell setup to evaluate in your profile",
+			ArgsUsage: "<bash|zsh|fish>",
+			Action:    ShowInit,
+		},
+		{
+			Name:      "shell",
+			Usage:     "Use Python <version> in the current shell only",
+			ArgsUsage: "<version> --unset",
+			Flags: []cli.Flag{
+				cli.BoolFlag{Name: "unset", Usage: "stop overriding the version in this shell"},
+			},
+			Action: ShowShellVersion,
+		},
+		{
+			Name:   "sh-shell",
+			Hidden: true,
+			Flags: []cli.Flag{
+				cli.BoolFlag{Name: "unset"},
+			},
+			Action: SetShellVersion,
+		},
 		{
 			Name:    "default",
 			Aliases: []string{"disable"},
@@ -110,7 +461,7 @@ Name:
 {{.Name}}{{if .Usage}} - {{.Usage}}{{end}}
 
 Commands:
-    gop <version>{{ "\t" }}Activate to Python <version>{{range .Commands}}
+    gop <version>{{ "\t" }}Activate to Python <version>{{range .VisibleCommands}}
     gop {{join .Names ", "}} {{ .ArgsUsage }}{{ "\t"}}{{.Usage}}{{ if .Subcommands }}{{range .Subcommands}}{{ "\n        " }}gop {{ .HelpName }} {{ .ArgsUsage }}{{ "\t"}}{{.Usage}}{{end}}{{end}}{{end}}
 
 Options:
@@ -124,24 +475,96 @@ Options:
 var errNoVersionString = fmt.Errorf("no version string given")
 
 func getVersionString(c *cli.Context) (string, error) {
+	return resolveVersionArg(c, ResolveOptions{})
+}
+
+// resolveVersionArg resolves the version given as the first argument of the command
+func resolveVersionArg(c *cli.Context, opts ResolveOptions) (string, error) {
 	if !c.Args().Present() {
 		return "", errNoVersionString
 	}
-	vstr := c.Args().First()
+	return cliManager(c).ResolveVersionWithOptions(c.Args().First(), opts)
+}
+
+// documentRequested reports whether the output is a document, with --json or --format
+func documentRequested(c *cli.Context) bool {
+	return c.GlobalBool("json") || c.GlobalString("format") != ""
+}
+
+// printDocument prints one of the documents of output.go as indented JSON, or with the --format template
+func printDocument(c *cli.Context, doc interface{}) error {
+	if format := c.GlobalString("format"); format != "" {
+		tmpl, err := template.New("format").Parse(format)
+		if err != nil {
+			return fmt.Errorf("invalid --format template: %w", err)
+		}
+		if err := tmpl.Execute(os.Stdout, doc); err != nil {
+			return err
+		}
+		fmt.Println()
+		return nil
+	}
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
 
-	return cleanVersionString(vstr)
+// printVersionsDocument prints the versions with --json or --format
+func printVersionsDocument(c *cli.Context, versions []string, currentVersion string) error {
+	m := cliManager(c)
+	installed, err := m.GetInstalledVersions()
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	doc := VersionsDocument{DocumentHeader: newDocumentHeader(kindVersions), Versions: []VersionOutput{}}
+	for _, vstr := range versions {
+		version, err := m.describeVersion(vstr, stringContains(installed, vstr), currentVersion)
+		if err != nil {
+			return err
+		}
+		doc.Versions = append(doc.Versions, version)
+	}
+	return printDocument(c, doc)
+}
+
+// printVersionDocument prints a single version with --json or --format
+func printVersionDocument(c *cli.Context, vstr string) error {
+	m := cliManager(c)
+	currentVersion, err := m.GetCurrentVersion()
+	if err != nil && !errors.Is(err, ErrNotActive) {
+		return err
+	}
+	installed, err := m.isVersionInstalled(vstr)
+	if err != nil {
+		return err
+	}
+	version, err := m.describeVersion(vstr, installed, currentVersion)
+	if err != nil {
+		return err
+	}
+	return printDocument(c, VersionDocument{DocumentHeader: newDocumentHeader(kindVersion), VersionOutput: version})
 }
 
 // ListAvailable .
 func ListAvailable(c *cli.Context) error {
-	versions, err := GetAvailableVersions()
+	if c.Bool("status") {
+		return ListReleaseStatus(c)
+	}
+	m := cliManager(c)
+	versions, err := m.GetAvailableVersionsWithOptions(ListOptions{IncludePre: c.Bool("pre")})
 	if err != nil {
 		return err
 	}
-	currentVersion, err := GetCurrentVersion()
-	if err != nil {
+	currentVersion, err := m.GetCurrentVersion()
+	if err != nil && !errors.Is(err, ErrNotActive) {
 		return err
 	}
+	if documentRequested(c) {
+		return printVersionsDocument(c, versions, currentVersion)
+	}
 
 	for _, vStr := range versions {
 		if vStr == currentVersion {
@@ -153,16 +576,73 @@ func ListAvailable(c *cli.Context) error {
 	return nil
 }
 
+// ListReleaseStatus outputs the support phase and end of life date of each minor line,
+// with its latest available release
+func ListReleaseStatus(c *cli.Context) error {
+	m := cliManager(c)
+	versions, err := m.GetAvailableVersionsWithOptions(ListOptions{IncludePre: c.Bool("pre")})
+	if err != nil {
+		return err
+	}
+	currentVersion, err := m.GetCurrentVersion()
+	if err != nil && !errors.Is(err, ErrNotActive) {
+		return err
+	}
+	currentLine := ""
+	if pver, err := parsePyVersion(currentVersion); err == nil {
+		currentLine = minorLine(pver)
+	}
+
+	doc := ReleaseStatusDocument{DocumentHeader: newDocumentHeader(kindReleaseStatus), Lines: []ReleaseStatusOutput{}}
+	for _, rs := range m.GetReleaseStatuses() {
+		lineSpec, err := parseVersionSpec(rs.Line)
+		if err != nil {
+			continue
+		}
+		line := ReleaseStatusOutput{
+			Line:         rs.Line,
+			Status:       rs.Status,
+			FirstRelease: rs.FirstRelease,
+			EndOfLife:    rs.EndOfLife,
+			Active:       rs.Line == currentLine,
+		}
+		if latest, ok := lineSpec.newestMatching(versions); ok {
+			line.Latest = latest
+		}
+		doc.Lines = append(doc.Lines, line)
+	}
+	if documentRequested(c) {
+		return printDocument(c, doc)
+	}
+
+	for _, line := range doc.Lines {
+		latest := line.Latest
+		if latest == "" {
+			latest = "-"
+		}
+		marker := "   "
+		if line.Active {
+			marker = "-->"
+		}
+		fmt.Printf("%s %-5s %-12s %-9s first release %-10s  end of life %s\n", marker, line.Line, line.Status, latest, line.FirstRelease, line.EndOfLife)
+	}
+	return nil
+}
+
 // ListInstalled .
 func ListInstalled(c *cli.Context) error {
-	versions, err := GetInstalledVersions()
+	m := cliManager(c)
+	versions, err := m.GetInstalledVersions()
 	if err != nil {
 		return err
 	}
-	currentVersion, err := GetCurrentVersion()
-	if err != nil {
+	currentVersion, err := m.GetCurrentVersion()
+	if err != nil && !errors.Is(err, ErrNotActive) {
 		return err
 	}
+	if documentRequested(c) {
+		return printVersionsDocument(c, versions, currentVersion)
+	}
 
 	for _, vStr := range versions {
 		if vStr == currentVersion {
@@ -176,52 +656,99 @@ func ListInstalled(c *cli.Context) error {
 
 // ShowLatest .
 func ShowLatest(c *cli.Context) error {
-	latest, err := GetLatestVersion()
+	latest, err := cliManager(c).GetLatestVersionWithOptions(ListOptions{IncludePre: c.Bool("pre")})
 	if err != nil {
 		return err
 	}
+	if documentRequested(c) {
+		return printVersionDocument(c, latest)
+	}
 	fmt.Println(latest)
 	return nil
 }
 
 // ShowStable .
 func ShowStable(c *cli.Context) error {
-	stable, err := GetStableVersion()
+	stable, err := cliManager(c).GetStableVersion()
 	if err != nil {
 		return err
 	}
+	if documentRequested(c) {
+		return printVersionDocument(c, stable)
+	}
 	fmt.Println(stable)
 	return nil
 }
 
+// yesNo formats a boolean for the status output
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+// compareStatus formats whether vstr is the given mirror version, which is empty if the mirror could not be reached
+func compareStatus(vstr string, mirrorVersion string) string {
+	if mirrorVersion == "" {
+		return "unknown"
+	}
+	return yesNo(vstr == mirrorVersion)
+}
+
 // ShowStatus .
 func ShowStatus(c *cli.Context) error {
-	vstr, err := GetCurrentVersion()
+	status, err := cliManager(c).GetStatus()
 	if err != nil {
 		return err
 	}
-	fmt.Println("current version:", vstr)
+	if documentRequested(c) {
+		return printDocument(c, StatusDocument{DocumentHeader: newDocumentHeader(kindStatus), Status: status})
+	}
+	fmt.Printf("%12s : %s\n", "version", status.Version)
+	fmt.Printf("%12s : %s\n", "source", status.SelectedBy)
+	if status.SelectedBy != globalSource {
+		active := status.Active
+		if active == "" {
+			active = "none"
+		}
+		fmt.Printf("%12s : %s\n", "global", active)
+	}
+	fmt.Printf("%12s : %s\n", "bin", status.Executable)
+	if status.Version != systemVersion {
+		fmt.Printf("%12s : %s\n", "latest", compareStatus(status.Version, status.Latest))
+		fmt.Printf("%12s : %s\n", "stable", compareStatus(status.Version, status.Stable))
+	}
+	fmt.Printf("%12s : %s (%s)\n", "on PATH", yesNo(status.OnPath), status.BinDir)
+	if status.OnPath {
+		shadowed := "no"
+		if status.ShadowedBy != "" {
+			shadowed = "yes, by " + status.ShadowedBy
+		}
+		fmt.Printf("%12s : %s\n", "shadowed", shadowed)
+	}
 	return nil
 }
 
 // ActivateLatest installs (if necessary) and activates the latest available version of python
 func ActivateLatest(c *cli.Context) error {
-	latest, err := GetLatestVersion()
+	m := cliManager(c)
+	latest, err := m.GetLatestVersionWithOptions(ListOptions{IncludePre: c.Bool("pre")})
 	if err != nil {
 		return err
 	}
 
-	isInstalled, err := isVersionInstalled(latest)
+	isInstalled, err := m.isVersionInstalled(latest)
 	if err != nil {
 		return err
 	}
 	if !isInstalled {
-		if err := InstallPythonVersion(latest, false); err != nil {
+		if err := m.InstallPythonVersion(latest, false); err != nil {
 			return err
 		}
 	}
 
-	if err = ActivatePythonVersion(latest); err != nil {
+	if err = m.ActivatePythonVersion(latest); err != nil {
 		return err
 	}
 	fmt.Println(latest)
@@ -230,22 +757,23 @@ func ActivateLatest(c *cli.Context) error {
 
 // ActivateStable installs (if necessary) and activates the latest stable version of python
 func ActivateStable(c *cli.Context) error {
-	stable, err := GetStableVersion()
+	m := cliManager(c)
+	stable, err := m.GetStableVersion()
 	if err != nil {
 		return err
 	}
 
-	isInstalled, err := isVersionInstalled(stable)
+	isInstalled, err := m.isVersionInstalled(stable)
 	if err != nil {
 		return err
 	}
 	if !isInstalled {
-		if err := InstallPythonVersion(stable, false); err != nil {
+		if err := m.InstallPythonVersion(stable, false); err != nil {
 			return err
 		}
 	}
 
-	if err = ActivatePythonVersion(stable); err != nil {
+	if err = m.ActivatePythonVersion(stable); err != nil {
 		return err
 	}
 	fmt.Println(stable)
@@ -254,52 +782,210 @@ func ActivateStable(c *cli.Context) error {
 
 // ActivateVersion installs and activates the given version of python
 func ActivateVersion(c *cli.Context) error {
+	m := cliManager(c)
 	// get version string
 	vstr, err := getVersionString(c)
 	if err == errNoVersionString {
-		return ListInstalled(c)
+		return ActivateLocal(c)
 	} else if err != nil {
 		return err
 	}
 	logger.Debugf("specified version: %s", vstr)
 
 	// is is installed?
-	installedVersions, err := GetInstalledVersions()
+	installedVersions, err := m.GetInstalledVersions()
 	if err != nil {
 		return err
 	}
 	if !stringContains(installedVersions, vstr) {
 		logger.Infof("version %s not installed, installing...", vstr)
-		if err = InstallPythonVersion(vstr, false); err != nil {
+		if err = m.InstallPythonVersion(vstr, false); err != nil {
 			return err
 		}
 	}
 
 	// activate it
-	if err := ActivatePythonVersion(vstr); err != nil {
+	if err := m.ActivatePythonVersion(vstr); err != nil {
 		return err
 	}
 	fmt.Println("activated", vstr)
 	return nil
 }
 
+// ActivateLocal installs (if necessary) and activates the version pinned in the nearest .python-version file.
+// Without one, the installed versions are listed.
+func ActivateLocal(c *cli.Context) error {
+	m := cliManager(c)
+	local, filename, err := m.GetLocalVersion()
+	if errors.Is(err, ErrNoLocalVersion) {
+		return ListInstalled(c)
+	} else if err != nil {
+		return err
+	}
+	logger.Infof("using %s from %s", local, filename)
+
+	if local == systemVersion {
+		return ActivateDefault(c)
+	}
+	vstr, err := m.ResolveVersion(local)
+	if err != nil {
+		return err
+	}
+
+	isInstalled, err := m.isVersionInstalled(vstr)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if !isInstalled {
+		logger.Infof("version %s not installed, installing...", vstr)
+		if err = m.InstallPythonVersion(vstr, false); err != nil {
+			return err
+		}
+	}
+
+	if err := m.ActivatePythonVersion(vstr); err != nil {
+		return err
+	}
+	fmt.Printf("activated %s (from %s)\n", vstr, filename)
+	return nil
+}
+
+// SetLocalVersion writes the given versions to .python-version in the working directory
+func SetLocalVersion(c *cli.Context) error {
+	m := cliManager(c)
+	if c.Bool("unset") {
+		filename, err := m.RemoveLocalVersionFile(".")
+		if err != nil {
+			return err
+		}
+		fmt.Println("removed", filename)
+		return nil
+	}
+	if !c.Args().Present() {
+		local, filename, err := m.GetLocalVersion()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s (from %s)\n", local, filename)
+		return nil
+	}
+
+	cwd, err := m.fs.Getwd()
+	if err != nil {
+		return err
+	}
+	filename, err := m.WriteLocalVersionFile(cwd, c.Args())
+	if err != nil {
+		return err
+	}
+	fmt.Printf("wrote %s to %s\n", strings.Join(c.Args(), ", "), filename)
+	return nil
+}
+
+// getInstallOptions reads the options of installFlags
+func getInstallOptions(c *cli.Context) (InstallOptions, error) {
+	opts := InstallOptions{
+		Force:              c.Bool("force"),
+		InsecureSkipVerify: c.Bool("insecure-skip-verify"),
+		Keyring:            c.String("keyring"),
+		Checksums:          c.String("checksums"),
+		CFLAGS:             c.String("cflags"),
+		LDFLAGS:            c.String("ldflags"),
+		Strict:             c.Bool("strict"),
+	}
+	if c.IsSet("configure-opts") {
+		opts.ConfigureOpts = splitOpts(c.String("configure-opts"))
+	}
+	if c.IsSet("make-opts") {
+		opts.MakeOpts = splitOpts(c.String("make-opts"))
+	}
+	if c.Int("jobs") > 0 {
+		if opts.MakeOpts == nil {
+			opts.MakeOpts = cliManager(c).Config().PMakeOpts
+		}
+		// --jobs wins over the number of jobs in GOP_MAKE_OPTS or --make-opts
+		opts.MakeOpts = withJobs(opts.MakeOpts, c.Int("jobs"))
+	}
+	if c.Bool("prebuilt") && c.Bool("from-source") {
+		return opts, fmt.Errorf("--prebuilt and --from-source are mutually exclusive")
+	} else if c.Bool("prebuilt") {
+		opts.From = installFromPrebuilt
+	} else if c.Bool("from-source") {
+		opts.From = installFromSource
+	}
+	return opts, nil
+}
+
 // InstallVersion installs the specified version of python but does not activate
 func InstallVersion(c *cli.Context) error {
-	// get version string
-	vstr, err := getVersionString(c)
+	// the newest matching release, even if an older one is installed
+	vstr, err := resolveVersionArg(c, ResolveOptions{Available: true})
 	if err != nil {
 		return err
 	}
 	logger.Debugf("specified version: %s", vstr)
 
-	force := c.Bool("force")
-	if err = InstallPythonVersion(vstr, force); err != nil {
+	opts, err := getInstallOptions(c)
+	if err != nil {
+		return err
+	}
+	if err = cliManager(c).InstallPythonVersionWithOptions(vstr, opts); err != nil {
 		return err
 	}
 	fmt.Println(vstr)
 	return nil
 }
 
+// ShowOutdated lists the installed minor versions with a newer patch release
+func ShowOutdated(c *cli.Context) error {
+	upgrades, err := cliManager(c).GetOutdated(c.Args().First())
+	if err != nil {
+		return err
+	}
+	if len(upgrades) == 0 {
+		fmt.Println("all installed versions are up to date")
+		return nil
+	}
+	for _, upgrade := range upgrades {
+		installed := ""
+		if upgrade.Installed {
+			installed = " (installed)"
+		}
+		fmt.Printf("%s: %s -> %s%s\n", upgrade.Line, strings.Join(upgrade.From, ", "), upgrade.To, installed)
+	}
+	return nil
+}
+
+// UpgradeVersions installs the newest patch release of each installed minor version, or of the given one
+func UpgradeVersions(c *cli.Context) error {
+	m := cliManager(c)
+	installOpts, err := getInstallOptions(c)
+	if err != nil {
+		return err
+	}
+	opts := UpgradeOptions{
+		Install:     installOpts,
+		Prune:       c.Bool("prune"),
+		UpdateLocal: c.Bool("update-local"),
+	}
+
+	upgrades, err := m.GetOutdated(c.Args().First())
+	if err != nil {
+		return err
+	}
+	if len(upgrades) == 0 {
+		fmt.Println("all installed versions are up to date")
+		return nil
+	}
+	for _, upgrade := range upgrades {
+		if err := m.UpgradePythonVersion(upgrade, opts); err != nil {
+			return err
+		}
+		fmt.Printf("%s: %s -> %s\n", upgrade.Line, strings.Join(upgrade.From, ", "), upgrade.To)
+	}
+	return nil
+}
+
 // UseVersion executes a command with the given arguments
 func UseVersion(c *cli.Context) error {
 	// get version string
@@ -308,7 +994,7 @@ func UseVersion(c *cli.Context) error {
 		return err
 	}
 	logger.Debugf("specified version: %s", vstr)
-	return CallWithVersion(vstr, c.Args().Tail())
+	return cliManager(c).CallWithVersion(vstr, c.Args().Tail())
 }
 
 // ShowVersion displays the path to the specified version of python
@@ -320,14 +1006,98 @@ func ShowVersion(c *cli.Context) error {
 	}
 	logger.Debugf("specified version: %s", vstr)
 
-	files, err := VersionFiles(vstr)
+	files, err := cliManager(c).VersionFiles(vstr)
 	if err != nil {
 		return err
 	}
+	if documentRequested(c) {
+		return printVersionDocument(c, vstr)
+	}
 	fmt.Println(files.Executable)
 	return nil
 }
 
+// ShowInfo displays the location of the specified version and how it was built
+func ShowInfo(c *cli.Context) error {
+	vstr, err := getVersionString(c)
+	if err != nil {
+		return err
+	}
+	logger.Debugf("specified version: %s", vstr)
+
+	m := cliManager(c)
+	if documentRequested(c) {
+		currentVersion, err := m.GetCurrentVersion()
+		if err != nil && !errors.Is(err, ErrNotActive) {
+			return err
+		}
+		doc, err := m.describeInfo(vstr, currentVersion)
+		if err != nil {
+			return err
+		}
+		return printDocument(c, doc)
+	}
+
+	files, err := m.VersionFiles(vstr)
+	if err != nil {
+		return err
+	}
+	fmt.Println("version:", vstr)
+	fmt.Println("bin:", files.Executable)
+
+	info, err := m.GetBuildInfo(vstr)
+	if os.IsNotExist(err) {
+		fmt.Println("no build information recorded")
+		return nil
+	} else if err != nil {
+		return err
+	}
+	fmt.Println("installed from:", info.Method)
+	fmt.Println("source:", info.Source)
+	if info.Method == installFromSource {
+		fmt.Println("configure options:", strings.Join(info.ConfigureOpts, " "))
+		fmt.Println("make options:", strings.Join(info.MakeOpts, " "))
+		fmt.Println("CFLAGS:", info.CFLAGS)
+		fmt.Println("LDFLAGS:", info.LDFLAGS)
+	}
+	if info.LogFile != "" {
+		fmt.Println("build log:", info.LogFile)
+	}
+	if len(info.MissingModules) > 0 {
+		fmt.Println("missing modules:", strings.Join(info.MissingModules, " "))
+	}
+	fmt.Println("installed at:", info.InstalledAt.Format(time.RFC3339))
+	return nil
+}
+
+// ShowLogs lists the build logs, or outputs the latest one for the given version
+func ShowLogs(c *cli.Context) error {
+	m := cliManager(c)
+	spec := c.Args().First()
+	logs, err := m.GetBuildLogs(spec)
+	if err != nil {
+		return err
+	}
+	if spec == "" {
+		for _, logFile := range logs {
+			fmt.Println(logFile)
+		}
+		return nil
+	}
+	if len(logs) == 0 {
+		return fmt.Errorf("no build logs for %s in %s", spec, m.GetLogsDir())
+	}
+
+	latest := logs[len(logs)-1]
+	data, err := m.fs.ReadFile(latest)
+	if err != nil {
+		return err
+	}
+	logger.Infof("showing %s", latest)
+	fmt.Print(string(data))
+	return nil
+}
+
 // RemoveVersion uninstalls the specified version
 func RemoveVersion(c *cli.Context) error {
 	// get version string
@@ -336,23 +1106,132 @@ func RemoveVersion(c *cli.Context) error {
 		return err
 	}
 	logger.Debugf("specified version: %s", vstr)
-	if err := UninstallPythonVersion(vstr); err != nil {
+	if err := cliManager(c).UninstallPythonVersion(vstr); err != nil {
+		return err
+	}
+	fmt.Println("uninstalled", vstr)
+	return nil
+}
+
+// RehashShims regenerates the shims directory
+func RehashShims(c *cli.Context) error {
+	m := cliManager(c)
+	names, err := m.Rehash()
+	if err != nil {
+		return err
+	}
+	shimsDir := m.GetShimsDir()
+	fmt.Printf("%d shims written to %s\n", len(names), shimsDir)
+	if !stringContains(filepath.SplitList(os.Getenv("PATH")), shimsDir) {
+		fmt.Printf("add %s to the front of your PATH to use them\n", shimsDir)
+	}
+	return nil
+}
+
+// SyncMirrorDir populates a local mirror directory from the remote mirrors
+func SyncMirrorDir(c *cli.Context) error {
+	if !c.Args().Present() {
+		return fmt.Errorf("no directory given")
+	}
+	if c.String("versions") == "" {
+		return fmt.Errorf("no versions given, use --versions (e.g. --versions 3.9-3.12)")
+	}
+	dir := c.Args().First()
+	synced, err := cliManager(c).SyncMirror(dir, c.String("versions"))
+	for _, vstr := range synced {
+		fmt.Println(vstr)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d version(s) synced, use P_MIRROR=%s on hosts without internet access\n", len(synced), dir)
+	return nil
+}
+
+// ExecShim runs a command from the version selected by GOP_VERSION, .python-version, or the global default
+func ExecShim(c *cli.Context) error {
+	if !c.Args().Present() {
+		return fmt.Errorf("no command given")
+	}
+	return cliManager(c).ExecCommand(c.Args().First(), c.Args().Tail())
+}
+
+// ShowInit outputs the snippet setting up PATH and the `gop` shell function
+func ShowInit(c *cli.Context) error {
+	if !c.Args().Present() {
+		return fmt.Errorf("no shell given, must be one of %s", strings.Join(supportedShells, ", "))
+	}
+	script, err := cliManager(c).InitScript(c.Args().First())
+	if err != nil {
+		return err
+	}
+	fmt.Print(script)
+	return nil
+}
+
+// ShowShellVersion is reached when `gop shell` is not intercepted by the shell function:
+// it shows the session's version, as it cannot change the environment of its parent shell
+func ShowShellVersion(c *cli.Context) error {
+	if c.Args().Present() || c.Bool("unset") {
+		return ErrNoShellIntegration
+	}
+	env := os.Getenv(versionEnvVar)
+	if env == "" {
+		return fmt.Errorf("no shell version set")
+	}
+	fmt.Println(env)
+	return nil
+}
+
+// SetShellVersion outputs the code for the `gop` shell function to evaluate, setting
+// the session's version override
+func SetShellVersion(c *cli.Context) error {
+	if c.Bool("unset") {
+		code, err := ShellCommands("")
+		if err != nil {
+			return err
+		}
+		fmt.Print(code)
+		return nil
+	}
+	if !c.Args().Present() {
+		env := os.Getenv(versionEnvVar)
+		if env == "" {
+			return fmt.Errorf("no shell version set")
+		}
+		fmt.Printf("echo %s\n", shellQuote(env))
+		return nil
+	}
+
+	// the output is evaluated by the shell, so nothing is installed here: the output of the
+	// installation could end up being run
+	spec := c.Args().First()
+	vstr, err := cliManager(c).resolveInstalled(spec)
+	if errors.Is(err, ErrNotInstalled) {
+		return fmt.Errorf("no installed version matches %s, install it first with `gop install %s`: %w", spec, spec, err)
+	} else if err != nil {
+		return err
+	}
+
+	code, err := ShellCommands(vstr)
+	if err != nil {
 		return err
 	}
-	fmt.Println("uninstalled %s", vstr)
+	fmt.Print(code)
 	return nil
 }
 
 // ActivateDefault reverts the to default sytem python
 func ActivateDefault(c *cli.Context) error {
-	if err := Deactivate(); err != nil {
+	m := cliManager(c)
+	if err := m.Deactivate(); err != nil {
 		return err
 	}
-	vstr, err := GetCurrentVersion()
+	vstr, err := m.GetSystemVersion()
 	if err != nil {
 		logger.Errorf("no system python installed!")
 		return err
 	}
-	fmt.Println("system python: %s", vstr)
+	fmt.Println("system python:", vstr)
 	return nil
 }
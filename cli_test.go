@@ -0,0 +1,45 @@
+package pgo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestExitCode(t *testing.T) {
+	download := &DownloadError{URL: "https://www.python.org/ftp/python/3.12.1/Python-3.12.1.tgz.asc", Status: 404}
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{nil, 0},
+		{errors.New("something else"), ExitFailure},
+		{fmt.Errorf("invalid version spec 3.x.1: %w", ErrInvalidVersion), ExitInvalidVersion},
+		{&VersionNotFoundError{Spec: "3.99"}, ExitVersionNotFound},
+		{fmt.Errorf("3.12.1: %w", ErrNotInstalled), ExitNotInstalled},
+		{download, ExitDownload},
+		{fmt.Errorf("%w: Python-3.12.1.tgz would have to be downloaded", ErrOffline), ExitOffline},
+		{fmt.Errorf("sha256 of Python-3.12.1.tgz: %w", ErrVerificationFailed), ExitVerification},
+		{fmt.Errorf("unable to verify Python-3.12.1.tgz: %w", ErrNotVerified), ExitVerification},
+		{&BuildError{Step: "make", ExitCode: 2, Err: errors.New("exit status 2")}, ExitBuild},
+		{fmt.Errorf("%w after 1m0s", ErrLockTimeout), ExitLockTimeout},
+		{fmt.Errorf("downloading: %w", context.DeadlineExceeded), ExitTimeout},
+		{fmt.Errorf("building: %w", context.Canceled), ExitInterrupted},
+
+		// signatures and manifests that cannot be downloaded are download errors, however they are wrapped
+		{fmt.Errorf("unable to get signature for Python-3.12.1.tgz: %w", download), ExitDownload},
+		{fmt.Errorf("unable to read checksum manifest: %w", &DownloadError{URL: "file:///srv/SHA256SUMS", Err: os.ErrNotExist}), ExitDownload},
+		{fmt.Errorf("%w: %w", ErrVerificationFailed, download), ExitDownload},
+		{fmt.Errorf("%w: %w", ErrNotVerified, download), ExitDownload},
+		// offline and interrupted downloads are not
+		{&DownloadError{URL: download.URL, Err: context.Canceled}, ExitInterrupted},
+		{fmt.Errorf("%w: %w", ErrOffline, download), ExitOffline},
+	}
+	for _, test := range tests {
+		if got := ExitCode(test.err); got != test.want {
+			t.Errorf("ExitCode(%v) = %d, want %d", test.err, got, test.want)
+		}
+	}
+}
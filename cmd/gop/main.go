@@ -8,9 +8,19 @@ import (
 )
 
 func main() {
+	// shims run before every python command, and skip the command line interface
+	if len(os.Args) > 1 && os.Args[1] == gop.ShimCommand {
+		if err := gop.RunShim(os.Args[2:]); err != nil {
+			log.Print(err)
+			os.Exit(gop.ExitCode(err))
+		}
+		return
+	}
+
 	app := gop.MakeApp()
 	err := app.Run(os.Args)
 	if err != nil {
-		log.Fatal(err)
+		log.Print(err)
+		os.Exit(gop.ExitCode(err))
 	}
 }
@@ -0,0 +1,284 @@
+package pgo
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// suffix of files being downloaded, renamed away once complete
+	partSuffix = ".part"
+	// suffix of the file next to a downloaded installer recording the URL it came from, redacted
+	originSuffix = ".origin"
+	// number of attempts at a download failing with transient errors
+	downloadAttempts = 4
+	// wait before the first retry, doubled for each of the next ones
+	downloadBackoff = time.Second
+	// how often the progress of a download is reported
+	progressInterval = 200 * time.Millisecond
+)
+
+// DownloadError is a failed download from a mirror
+type DownloadError struct {
+	// URL is the location of the download, with credentials redacted
+	URL string
+	// Status is the HTTP status of the response, 0 if none was received
+	Status int
+	// Err is the cause of the failure, nil if the status was not the one expected
+	Err error
+}
+
+func (e *DownloadError) Error() string {
+	switch {
+	case e.Err == nil:
+		return fmt.Sprintf("GET %s: %d %s", e.URL, e.Status, http.StatusText(e.Status))
+	case e.Status == 0:
+		return fmt.Sprintf("%s: %s", e.URL, e.Err)
+	}
+	return fmt.Sprintf("GET %s: %s", e.URL, e.Err)
+}
+
+func (e *DownloadError) Unwrap() error {
+	return e.Err
+}
+
+// incompleteDownloadError is a download that ended early or could not be resumed, and is worth another attempt
+type incompleteDownloadError struct {
+	msg string
+}
+
+func (e *incompleteDownloadError) Error() string {
+	return e.msg
+}
+
+// isTransient reports whether a failed download is worth retrying: network errors and
+// interrupted bodies are, as are server errors, timeouts and rate limiting, but not other
+// 4xx statuses, certificate errors, errors writing the file or a cancelled operation
+func isTransient(err error) bool {
+	var downloadErr *DownloadError
+	if errors.As(err, &downloadErr) && downloadErr.Err == nil {
+		status := downloadErr.Status
+		return status >= 500 || status == http.StatusRequestTimeout ||
+			status == http.StatusTooManyRequests || status == http.StatusRequestedRangeNotSatisfiable
+	}
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var invalid x509.CertificateInvalidError
+	if errors.As(err, &unknownAuthority) || errors.As(err, &hostname) || errors.As(err, &invalid) {
+		return false
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var incomplete *incompleteDownloadError
+	var netErr net.Error
+	return errors.As(err, &incomplete) || errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// downloadFile writes the body of the URL to targetFile, or copies it from a local mirror.
+// The data goes to targetFile.part first, so that an interrupted (or cancelled) download is resumed
+// where it stopped and targetFile only ever exists complete.
+func (m *Manager) downloadFile(location string, targetFile string) error {
+	partFile := targetFile + partSuffix
+	if source, ok := localMirrorPath(location); ok {
+		size := int64(-1)
+		if info, err := m.fs.Stat(source); err == nil {
+			size = info.Size()
+		}
+		m.emit(Event{Type: EventDownloading, URL: location, Total: size})
+		if err := m.copyFile(source, partFile); err != nil {
+			return &DownloadError{URL: location, Err: err}
+		}
+		m.emit(Event{Type: EventDownloaded, URL: location, Bytes: size, Total: size})
+		return m.fs.Rename(partFile, targetFile)
+	}
+
+	backoff := downloadBackoff
+	for attempt := 1; ; attempt++ {
+		err := m.downloadPart(location, partFile)
+		if err == nil {
+			break
+		}
+		if !isTransient(err) || attempt == downloadAttempts {
+			return err
+		}
+		logger.Warningf("download of %s failed (attempt %d of %d), retrying in %s: %s", redactURL(location), attempt, downloadAttempts, backoff, err)
+		select {
+		case <-m.context().Done():
+			return m.context().Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+	return m.fs.Rename(partFile, targetFile)
+}
+
+// downloadPart appends the rest of the URL to partFile, starting over if the server cannot resume
+func (m *Manager) downloadPart(location string, partFile string) error {
+	var offset int64
+	if info, err := m.fs.Stat(partFile); err == nil {
+		offset = info.Size()
+	}
+
+	resp, err := m.httpGetFrom(location, offset)
+	var downloadErr *DownloadError
+	if errors.As(err, &downloadErr) && downloadErr.Status == http.StatusRequestedRangeNotSatisfiable {
+		// the partial file does not match what the server has anymore
+		logger.Infof("cannot resume %s, starting over", partFile)
+		_ = m.fs.Remove(partFile)
+		return err
+	} else if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	if resp.StatusCode == http.StatusPartialContent {
+		if !strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", offset)) {
+			_ = m.fs.Remove(partFile)
+			return &DownloadError{URL: redactURL(location), Status: resp.StatusCode,
+				Err: &incompleteDownloadError{fmt.Sprintf("unexpected Content-Range %q resuming at %d", resp.Header.Get("Content-Range"), offset)}}
+		}
+		logger.Infof("resuming %s at %d bytes", partFile, offset)
+	} else {
+		flags |= os.O_TRUNC
+		offset = 0
+	}
+	out, err := m.fs.OpenFile(partFile, flags, 0644)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	logger.Debugf("writing to %s", partFile)
+
+	total := int64(-1)
+	if resp.ContentLength >= 0 {
+		total = offset + resp.ContentLength
+	}
+	progress := &progressWriter{m: m, url: redactURL(location), bytes: offset, total: total}
+	m.emit(Event{Type: EventDownloading, URL: progress.url, Bytes: offset, Total: total})
+
+	written, err := io.Copy(out, io.TeeReader(resp.Body, progress))
+	if err != nil {
+		return &DownloadError{URL: progress.url, Status: resp.StatusCode, Err: err}
+	}
+	if total >= 0 && offset+written != total {
+		return &DownloadError{URL: progress.url, Status: resp.StatusCode,
+			Err: &incompleteDownloadError{fmt.Sprintf("ended after %d of %d bytes", offset+written, total)}}
+	}
+	m.emit(Event{Type: EventDownloaded, URL: progress.url, Bytes: offset + written, Total: total})
+	return nil
+}
+
+// progressWriter counts the bytes of a download, emitting EventProgress every progressInterval
+type progressWriter struct {
+	m       *Manager
+	url     string
+	bytes   int64
+	total   int64
+	emitted time.Time
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	p.bytes += int64(len(b))
+	if time.Since(p.emitted) >= progressInterval {
+		p.emitted = time.Now()
+		p.m.emit(Event{Type: EventProgress, URL: p.url, Bytes: p.bytes, Total: p.total, Time: p.emitted})
+	}
+	return len(b), nil
+}
+
+// copyFile copies a file from a local mirror
+func (m *Manager) copyFile(source string, targetFile string) error {
+	in, err := m.fs.OpenFile(source, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := m.fs.OpenFile(targetFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	logger.Debugf("copying %s to %s", source, targetFile)
+
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		_ = m.fs.Remove(targetFile)
+		return err
+	}
+	return nil
+}
+
+// isTerminal reports whether f is a terminal, where progress can be redrawn in place
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// progressBar draws the bytes received, rate and time left of a download on stderr
+type progressBar struct {
+	name    string
+	start   time.Time
+	initial int64
+	current int64
+	total   int64
+}
+
+func newProgressBar(name string, initial int64, total int64) *progressBar {
+	return &progressBar{name: name, start: time.Now(), initial: initial, current: initial, total: total}
+}
+
+// update sets the bytes received, and redraws the bar
+func (bar *progressBar) update(current int64) {
+	bar.current = current
+	bar.draw()
+}
+
+func (bar *progressBar) draw() {
+	elapsed := time.Since(bar.start).Seconds()
+	rate := 0.0
+	if elapsed > 0 {
+		rate = float64(bar.current-bar.initial) / elapsed
+	}
+
+	line := fmt.Sprintf("%s  %s", bar.name, formatBytes(bar.current))
+	if bar.total > 0 {
+		line += fmt.Sprintf(" / %s  %3d%%", formatBytes(bar.total), bar.current*100/bar.total)
+	}
+	line += fmt.Sprintf("  %s/s", formatBytes(int64(rate)))
+	if bar.total > 0 && rate > 0 {
+		eta := time.Duration(float64(bar.total-bar.current)/rate) * time.Second
+		line += fmt.Sprintf("  ETA %s", eta.Round(time.Second))
+	}
+	fmt.Fprintf(os.Stderr, "\r%-79s", line)
+}
+
+// finish draws the final state of the bar and ends its line
+func (bar *progressBar) finish() {
+	bar.draw()
+	fmt.Fprintln(os.Stderr)
+}
+
+// formatBytes formats a byte count with a binary unit, e.g. "25.3 MiB"
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,117 @@
+package pgo
+
+import (
+	"fmt"
+	"time"
+)
+
+// EventType is the step of an operation an Event reports
+type EventType string
+
+// steps of installing, uninstalling and activating versions, in the order they happen
+const (
+	// EventResolving is a version specification being resolved to a version, given in Message
+	EventResolving EventType = "resolving"
+	// EventDownloading starts a download from URL, followed by EventProgress and EventDownloaded
+	EventDownloading EventType = "downloading"
+	// EventProgress reports Bytes out of Total (-1 if unknown) downloaded from URL
+	EventProgress   EventType = "progress"
+	EventDownloaded EventType = "downloaded"
+	// EventVerifying is the downloaded file being checked against its checksum and signature
+	EventVerifying EventType = "verifying"
+	// EventExtracting is the source tarball or prebuilt distribution being unpacked
+	EventExtracting EventType = "extracting"
+	// EventConfiguring, EventCompiling and EventInstalling are ./configure, make and make install.
+	// Message is the build log. Prebuilt distributions skip to EventInstalling, with the unpacked directory.
+	EventConfiguring EventType = "configuring"
+	EventCompiling   EventType = "compiling"
+	EventInstalling  EventType = "installing"
+	// EventChecking is the installed python being run to check its version and optional modules
+	EventChecking EventType = "checking"
+	// EventInstalled is the version moved into place, Message is its directory
+	EventInstalled EventType = "installed"
+	// EventUninstalling is the directory in Message being removed
+	EventUninstalling EventType = "uninstalling"
+	EventUninstalled  EventType = "uninstalled"
+	// EventActivating and EventActivated switch the active links, in the directory given in Message
+	EventActivating EventType = "activating"
+	EventActivated  EventType = "activated"
+	// EventDeactivated is the current link, given in Message, being removed
+	EventDeactivated EventType = "deactivated"
+)
+
+// Event is a step of an operation of a Manager
+type Event struct {
+	Type EventType `json:"type"`
+	// Version is the version the operation is on, empty while it is being resolved
+	Version string `json:"version,omitempty"`
+	// Message details the step, see the EventType constants
+	Message string `json:"message,omitempty"`
+	// URL is the (redacted) location of downloads
+	URL   string    `json:"url,omitempty"`
+	Bytes int64     `json:"bytes,omitempty"`
+	Total int64     `json:"total,omitempty"`
+	Time  time.Time `json:"time"`
+}
+
+// String formats the event for logs, e.g. "configuring 3.12.4: /home/me/p/logs/python-3.12.4-20240607-101112.log"
+func (e Event) String() string {
+	s := string(e.Type)
+	if e.Version != "" {
+		s += " " + e.Version
+	}
+	if e.URL != "" {
+		s += " from " + e.URL
+	}
+	if e.Type == EventProgress {
+		s += fmt.Sprintf(": %s", formatBytes(e.Bytes))
+		if e.Total >= 0 {
+			s += fmt.Sprintf(" / %s", formatBytes(e.Total))
+		}
+	}
+	if e.Message != "" {
+		s += ": " + e.Message
+	}
+	return s
+}
+
+// EventHandler receives the events of a Manager's operations, from the goroutine running the operation
+type EventHandler interface {
+	HandleEvent(e Event)
+}
+
+// EventHandlerFunc is a function used as an EventHandler
+type EventHandlerFunc func(e Event)
+
+// HandleEvent calls f(e)
+func (f EventHandlerFunc) HandleEvent(e Event) {
+	f(e)
+}
+
+// emit sends the event to the handler given in ManagerOptions, if any
+func (m *Manager) emit(e Event) {
+	if m.events == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	m.events.HandleEvent(e)
+}
+
+// withEventVersion returns a copy of the manager whose events are about the given version,
+// for the steps (downloads, builds) that do not know it
+func (m *Manager) withEventVersion(versionStr string) *Manager {
+	if m.events == nil {
+		return m
+	}
+	derived := *m
+	events := m.events
+	derived.events = EventHandlerFunc(func(e Event) {
+		if e.Version == "" {
+			e.Version = versionStr
+		}
+		events.HandleEvent(e)
+	})
+	return &derived
+}
@@ -0,0 +1,165 @@
+package pgo
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// extractTarGz extracts a .tgz or .tar.gz archive into targetDir through the file system of the manager.
+// Entries that would land outside of targetDir are refused: names leaving it, symbolic links pointing
+// out of it or absolute, and entries written through a symbolic link, which could have been extracted
+// from the archive itself. Hard links are extracted as copies.
+func (m *Manager) extractTarGz(archiveFile string, targetDir string) error {
+	targetDir = filepath.Clean(targetDir)
+	f, err := m.fs.OpenFile(archiveFile, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("unable to read %s: %w", archiveFile, err)
+	}
+	defer gz.Close()
+
+	if err := m.fs.MkdirAll(targetDir, 0755); err != nil {
+		return err
+	}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("unable to read %s: %w", archiveFile, err)
+		}
+		if err := m.context().Err(); err != nil {
+			return err
+		}
+		target, err := extractPath(targetDir, hdr.Name)
+		if err != nil {
+			return err
+		}
+		if err := m.checkNoLinks(targetDir, target); err != nil {
+			return err
+		}
+		if err := m.extractEntry(tr, hdr, targetDir, target); err != nil {
+			return fmt.Errorf("unable to extract %s from %s: %w", hdr.Name, archiveFile, err)
+		}
+	}
+}
+
+// extractPath returns where an archive entry goes in targetDir
+func extractPath(targetDir string, name string) (string, error) {
+	target := filepath.Join(targetDir, filepath.FromSlash(name))
+	if target != targetDir && !strings.HasPrefix(target, targetDir+string(filepath.Separator)) {
+		return "", fmt.Errorf("archive entry %s is outside of the extraction directory", name)
+	}
+	return target, nil
+}
+
+// checkNoLinks refuses paths below targetDir that are, or go through, a symbolic link
+func (m *Manager) checkNoLinks(targetDir string, target string) error {
+	rel, err := filepath.Rel(targetDir, target)
+	if err != nil || rel == "." {
+		return err
+	}
+	current := targetDir
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		current = filepath.Join(current, part)
+		info, err := m.fs.Lstat(current)
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("archive entry %s would be written through the link %s", rel, current)
+		}
+	}
+	return nil
+}
+
+// checkLinkTarget refuses a symbolic link in dir to linkname unless it is relative and stays in targetDir.
+// The link is followed one component at a time, so it must not go through another link either.
+func (m *Manager) checkLinkTarget(targetDir string, dir string, linkname string) error {
+	name := filepath.FromSlash(linkname)
+	if filepath.IsAbs(name) || strings.HasPrefix(linkname, "/") || filepath.VolumeName(name) != "" {
+		return fmt.Errorf("link to the absolute path %s", linkname)
+	}
+	parts := strings.Split(name, string(filepath.Separator))
+	current := dir
+	for i, part := range parts {
+		if part == "" || part == "." {
+			continue
+		}
+		current = filepath.Join(current, part)
+		if current != targetDir && !strings.HasPrefix(current, targetDir+string(filepath.Separator)) {
+			return fmt.Errorf("link to %s is outside of the extraction directory", linkname)
+		}
+		if i == len(parts)-1 || part == ".." {
+			continue
+		}
+		if info, err := m.fs.Lstat(current); err == nil && info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("link to %s goes through the link %s", linkname, current)
+		}
+	}
+	return nil
+}
+
+// extractEntry writes the archive entry to target. Entries other than directories, files and links are skipped.
+func (m *Manager) extractEntry(tr *tar.Reader, hdr *tar.Header, targetDir string, target string) error {
+	mode := hdr.FileInfo().Mode().Perm()
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		return m.fs.MkdirAll(target, mode|0700)
+	case tar.TypeReg:
+		return m.writeExtracted(target, tr, mode)
+	case tar.TypeSymlink:
+		if err := m.checkLinkTarget(targetDir, filepath.Dir(target), hdr.Linkname); err != nil {
+			return err
+		}
+		if err := m.fs.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		return m.fs.Symlink(hdr.Linkname, target)
+	case tar.TypeLink:
+		source, err := extractPath(targetDir, hdr.Linkname)
+		if err != nil {
+			return err
+		}
+		if err := m.checkNoLinks(targetDir, source); err != nil {
+			return err
+		}
+		data, err := m.fs.ReadFile(source)
+		if err != nil {
+			return err
+		}
+		return m.writeExtracted(target, bytes.NewReader(data), mode)
+	default:
+		logger.Debugf("skipping %s of type %c", hdr.Name, hdr.Typeflag)
+		return nil
+	}
+}
+
+// writeExtracted creates the file target with the contents of r
+func (m *Manager) writeExtracted(target string, r io.Reader, mode os.FileMode) error {
+	if err := m.fs.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	out, err := m.fs.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
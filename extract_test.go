@@ -0,0 +1,111 @@
+package pgo
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// tarEntry is a file, directory (name ending with /) or symbolic link (link set) of a test archive
+type tarEntry struct {
+	name string
+	link string
+	body string
+}
+
+// writeTarGz writes an archive of the entries to file
+func writeTarGz(t *testing.T, file string, entries []tarEntry) {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, entry := range entries {
+		hdr := &tar.Header{Name: entry.name, Mode: 0644, Size: int64(len(entry.body)), Typeflag: tar.TypeReg}
+		if entry.link != "" {
+			hdr.Typeflag, hdr.Linkname, hdr.Size = tar.TypeSymlink, entry.link, 0
+		} else if entry.name[len(entry.name)-1] == '/' {
+			hdr.Typeflag, hdr.Mode = tar.TypeDir, 0755
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(entry.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(file, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExtractTarGz(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symbolic links need privileges on windows")
+	}
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "release.tgz")
+	writeTarGz(t, archive, []tarEntry{
+		{name: "Python/"},
+		{name: "Python/bin/python3.12", body: "python"},
+		{name: "Python/bin/python3", link: "python3.12"},
+		{name: "Python/lib/"},
+		{name: "Python/lib/bin", link: "../bin"},
+	})
+	m := NewManager(Config{PPrefix: dir}, ManagerOptions{})
+
+	targetDir := filepath.Join(dir, "out")
+	if err := m.extractTarGz(archive, targetDir); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(targetDir, "Python", "lib", "bin", "python3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "python" {
+		t.Errorf("python3 through the links is %q", data)
+	}
+}
+
+func TestExtractTarGzRefusesLinksOutside(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symbolic links need privileges on windows")
+	}
+	tests := []struct {
+		name    string
+		entries []tarEntry
+	}{
+		{"absolute link", []tarEntry{{name: "a", link: "/etc"}}},
+		{"link to the parent", []tarEntry{{name: "a", link: ".."}}},
+		{"link through a link", []tarEntry{{name: "s", link: "."}, {name: "a", link: "s/../escaped"}}},
+		{"file through a link", []tarEntry{{name: "sub/"}, {name: "a", link: "sub"}, {name: "a/escaped", body: "x"}}},
+		{"file replacing a link", []tarEntry{{name: "sub/"}, {name: "a", link: "sub/escaped"}, {name: "a", body: "x"}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			archive := filepath.Join(dir, "release.tgz")
+			writeTarGz(t, archive, test.entries)
+			m := NewManager(Config{PPrefix: dir}, ManagerOptions{})
+
+			if err := m.extractTarGz(archive, filepath.Join(dir, "out")); err == nil {
+				t.Error("the archive was extracted")
+			}
+			for _, name := range []string{filepath.Join(dir, "escaped"), filepath.Join(dir, "out", "sub", "escaped")} {
+				if _, err := os.Lstat(name); err == nil {
+					t.Errorf("%s was written", name)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package pgo
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// path after prefix of the cached version index of the mirrors
+	indexPath = "p/index.json"
+	// how long a cached version index is used before it is downloaded again
+	defaultIndexTTL = 24 * time.Hour
+)
+
+// ErrOffline is wrapped by the errors of operations that would have to download something in offline mode
+var ErrOffline = fmt.Errorf("offline mode is set (--offline or GOP_OFFLINE)")
+
+// indexKey identifies the mirrors a version index was downloaded from in the cache
+func (m *Manager) indexKey() string {
+	mirrors := make([]string, 0, len(m.cfg.PMirrors))
+	for _, mirror := range m.cfg.PMirrors {
+		mirrors = append(mirrors, redactURL(mirror))
+	}
+	return strings.Join(mirrors, ",")
+}
+
+// cachedIndex is the version index of a list of mirrors, as found in its directory listing
+type cachedIndex struct {
+	FetchedAt time.Time `json:"fetched_at"`
+	Versions  []string  `json:"versions"`
+}
+
+// readIndexCache returns the cached version indexes, by mirror list
+func (m *Manager) readIndexCache() (map[string]cachedIndex, error) {
+	indexes := map[string]cachedIndex{}
+	data, err := m.fs.ReadFile(filepath.Join(m.cfg.PPrefix, indexPath))
+	if os.IsNotExist(err) {
+		return indexes, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &indexes); err != nil {
+		logger.Warningf("ignoring corrupt version index cache: %s", err)
+		return map[string]cachedIndex{}, nil
+	}
+	return indexes, nil
+}
+
+// writeIndexCache saves the version index of the mirrors, replacing the cache file atomically.
+// The indexes of other mirror lists are kept, under the cache lock so that none of them is lost
+// when several processes write the cache at once.
+func (m *Manager) writeIndexCache(key string, versions []pyVersion) error {
+	release, err := m.acquireLocks(cacheLock)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	indexes, err := m.readIndexCache()
+	if err != nil {
+		return err
+	}
+	index := cachedIndex{FetchedAt: time.Now(), Versions: make([]string, 0, len(versions))}
+	for _, pver := range versions {
+		index.Versions = append(index.Versions, pver.String())
+	}
+	indexes[key] = index
+
+	data, err := json.MarshalIndent(indexes, "", "  ")
+	if err != nil {
+		return err
+	}
+	indexFile := filepath.Join(m.cfg.PPrefix, indexPath)
+	if err := m.fs.MkdirAll(filepath.Dir(indexFile), 0755); err != nil {
+		return err
+	}
+	tmpFile := fmt.Sprintf("%s.%d", indexFile, os.Getpid())
+	if err := m.fs.WriteFile(tmpFile, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	return m.fs.Rename(tmpFile, indexFile)
+}
+
+// parseCachedIndex returns the versions of a cached index
+func parseCachedIndex(index cachedIndex) []pyVersion {
+	pyVers := newPyVersionOrderedSet()
+	for _, vstr := range index.Versions {
+		_ = pyVers.Add(vstr)
+	}
+	return pyVers.AsSlice()
+}
+
+// refreshIndex downloads the version index from the mirrors and caches it
+func (m *Manager) refreshIndex() ([]pyVersion, error) {
+	if m.cfg.POffline {
+		local := localMirrors(m.cfg.PMirrors)
+		if len(local) == 0 {
+			return nil, fmt.Errorf("%w: the version index of %s would have to be downloaded", ErrOffline, m.indexKey())
+		}
+		cfg := m.cfg
+		cfg.PMirrors = local
+		m = m.withConfig(cfg)
+	}
+	// the release directories already listed in the previous index are not listed again
+	known := []pyVersion{}
+	if indexes, err := m.readIndexCache(); err == nil {
+		known = parseCachedIndex(indexes[m.indexKey()])
+	}
+	versions, err := m.getMirrorVersions(known)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.writeIndexCache(m.indexKey(), versions); err != nil {
+		logger.Warningf("unable to cache the version index: %s", err)
+	}
+	return versions, nil
+}
+
+// getIndex returns the versions on the mirrors, from the cache if it is younger than the TTL.
+// In offline mode the cache is used whatever its age.
+func (m *Manager) getIndex() ([]pyVersion, error) {
+	// listing directories on disk is cheap, and must see what was just synced
+	if len(remoteMirrors(m.cfg.PMirrors)) == 0 {
+		return m.getMirrorVersions(nil)
+	}
+
+	indexes, err := m.readIndexCache()
+	if err != nil {
+		return nil, err
+	}
+	index, cached := indexes[m.indexKey()]
+	age := time.Since(index.FetchedAt)
+	if cached && (age < m.cfg.PIndexTTL || m.cfg.POffline) {
+		logger.Debugf("using version index of %s cached %s ago", m.indexKey(), age.Round(time.Second))
+		return parseCachedIndex(index), nil
+	}
+
+	versions, err := m.refreshIndex()
+	if err != nil && cached {
+		logger.Warningf("unable to refresh the version index, using the one cached %s ago: %s", age.Round(time.Second), err)
+		return parseCachedIndex(index), nil
+	}
+	return versions, err
+}
+
+// RefreshIndex downloads the version index of the mirrors of P_MIRROR again
+func RefreshIndex() error {
+	return defaultManager().RefreshIndex()
+}
+
+// RefreshIndex downloads the version index from the mirrors, replacing the cached one
+func (m *Manager) RefreshIndex() error {
+	_, err := m.refreshIndex()
+	return err
+}
@@ -0,0 +1,151 @@
+package pgo
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// name of the per-directory version file, compatible with pyenv
+	localVersionFile = ".python-version"
+	// version name in a version file meaning "use the system python"
+	systemVersion = "system"
+)
+
+// ErrNoLocalVersion is returned when no .python-version file is found
+var ErrNoLocalVersion = fmt.Errorf("no %s file found", localVersionFile)
+
+// FindLocalVersionFile looks for a .python-version file from dir up, on the disk
+func FindLocalVersionFile(dir string) (string, error) {
+	return defaultManager().FindLocalVersionFile(dir)
+}
+
+// FindLocalVersionFile walks up from dir to the root looking for a .python-version file.
+// It returns ErrNoLocalVersion if there is none.
+func (m *Manager) FindLocalVersionFile(dir string) (string, error) {
+	dir, err := m.absPath(dir)
+	if err != nil {
+		return "", err
+	}
+	for {
+		filename := filepath.Join(dir, localVersionFile)
+		if info, err := m.fs.Stat(filename); err == nil && !info.IsDir() {
+			return filename, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", ErrNoLocalVersion
+		}
+		dir = parent
+	}
+}
+
+// absPath returns dir made absolute against the working directory of the file system
+func (m *Manager) absPath(dir string) (string, error) {
+	if filepath.IsAbs(dir) {
+		return filepath.Clean(dir), nil
+	}
+	cwd, err := m.fs.Getwd()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(cwd, dir), nil
+}
+
+// ReadLocalVersionFile reads the versions of a .python-version file
+func ReadLocalVersionFile(filename string) ([]string, error) {
+	return defaultManager().ReadLocalVersionFile(filename)
+}
+
+// ReadLocalVersionFile returns the versions listed in a .python-version file, in order.
+// Blank lines and comments are skipped, and several versions may be given on one line.
+func (m *Manager) ReadLocalVersionFile(filename string) ([]string, error) {
+	data, err := m.fs.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := []string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if idx := strings.Index(line, "#"); idx >= 0 {
+			line = line[:idx]
+		}
+		versions = append(versions, strings.Fields(line)...)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("%s does not list any versions", filename)
+	}
+	return versions, nil
+}
+
+// WriteLocalVersionFile writes the versions to dir/.python-version
+func WriteLocalVersionFile(dir string, versions []string) (string, error) {
+	return defaultManager().WriteLocalVersionFile(dir, versions)
+}
+
+// WriteLocalVersionFile writes the given versions, one per line, to the .python-version file in dir
+func (m *Manager) WriteLocalVersionFile(dir string, versions []string) (string, error) {
+	for _, vstr := range versions {
+		if vstr == systemVersion {
+			continue
+		}
+		if _, err := parseVersionSpec(vstr); err != nil {
+			return "", err
+		}
+	}
+	filename := filepath.Join(dir, localVersionFile)
+	content := strings.Join(versions, "\n") + "\n"
+	if err := m.fs.WriteFile(filename, []byte(content), 0644); err != nil {
+		return "", err
+	}
+	return filename, nil
+}
+
+// RemoveLocalVersionFile deletes dir/.python-version
+func RemoveLocalVersionFile(dir string) (string, error) {
+	return defaultManager().RemoveLocalVersionFile(dir)
+}
+
+// RemoveLocalVersionFile removes the .python-version file in dir, and returns its path
+func (m *Manager) RemoveLocalVersionFile(dir string) (string, error) {
+	dir, err := m.absPath(dir)
+	if err != nil {
+		return "", err
+	}
+	filename := filepath.Join(dir, localVersionFile)
+	if err := m.fs.Remove(filename); err != nil {
+		return "", err
+	}
+	return filename, nil
+}
+
+// GetLocalVersion returns the first version of the .python-version file nearest to the working directory
+func GetLocalVersion() (string, string, error) {
+	return defaultManager().GetLocalVersion()
+}
+
+// GetLocalVersion returns the primary (first) version listed in the nearest .python-version file,
+// along with the path of that file
+func (m *Manager) GetLocalVersion() (string, string, error) {
+	cwd, err := m.fs.Getwd()
+	if err != nil {
+		return "", "", err
+	}
+	filename, err := m.FindLocalVersionFile(cwd)
+	if err != nil {
+		return "", "", err
+	}
+	versions, err := m.ReadLocalVersionFile(filename)
+	if err != nil {
+		return "", "", err
+	}
+	return versions[0], filename, nil
+}
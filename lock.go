@@ -0,0 +1,171 @@
+package pgo
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// path after prefix where lock files will be stored
+	locksPath = "p/locks"
+	// lock taken while the active version is changed
+	activateLock = "activate"
+	// lock taken while the shims are regenerated
+	shimsLock = "shims"
+	// lock taken while the cached version index or release status table is updated
+	cacheLock = "cache"
+	// lock of the whole prefix: shared while the installed and active versions are read, exclusive
+	// while they change (moving a version into place or out of it, switching the active links)
+	prefixLock = "prefix"
+	// default time to wait for a lock held by another process
+	defaultLockTimeout = 10 * time.Minute
+	// interval between attempts to take a lock held by another process
+	lockPollInterval = 200 * time.Millisecond
+)
+
+// ErrLockTimeout is wrapped when a lock held by another process is not released within P_LOCK_TIMEOUT
+var ErrLockTimeout = fmt.Errorf("timed out")
+
+// fileLock is an advisory lock on a file in the locks directory, exclusive unless shared is set
+type fileLock struct {
+	file   File
+	shared bool
+}
+
+// versionLock returns the name of the lock taken while a version is installed or uninstalled
+func versionLock(versionStr string) string {
+	return "python-" + versionStr
+}
+
+// lockHolder describes the process holding a lock, as recorded in the lock file by its holder
+func (m *Manager) lockHolder(filename string) string {
+	data, err := m.fs.ReadFile(filename)
+	if err != nil {
+		return "another process"
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return "another process"
+	}
+	return fmt.Sprintf("pid %d", pid)
+}
+
+// tryLock locks files on disk. Files of other file systems are not shared with other processes,
+// so they are always taken.
+func tryLock(f File, shared bool) (bool, error) {
+	if osFile, ok := f.(*os.File); ok {
+		return tryLockFile(osFile, shared)
+	}
+	return true, nil
+}
+
+func unlock(f File) error {
+	if osFile, ok := f.(*os.File); ok {
+		return unlockFile(osFile)
+	}
+	return nil
+}
+
+// acquireLock takes the named lock, waiting up to Config.PLockTimeout if another process holds it.
+// A shared lock is only held up by a process holding the lock exclusively.
+func (m *Manager) acquireLock(name string, shared bool) (*fileLock, error) {
+	locksDir := filepath.Join(m.cfg.PPrefix, locksPath)
+	filename := filepath.Join(locksDir, name+".lock")
+	if err := m.fs.MkdirAll(locksDir, 0755); err != nil {
+		return nil, err
+	}
+	f, err := m.fs.OpenFile(filename, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(m.cfg.PLockTimeout)
+	waiting := false
+	for {
+		ok, err := tryLock(f, shared)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			f.Close()
+			return nil, fmt.Errorf("%w after %s waiting for lock %s held by %s", ErrLockTimeout, m.cfg.PLockTimeout, filename, m.lockHolder(filename))
+		}
+		if !waiting {
+			logger.Warningf("waiting for lock %s held by %s", filename, m.lockHolder(filename))
+			waiting = true
+		}
+		select {
+		case <-m.context().Done():
+			f.Close()
+			return nil, m.context().Err()
+		case <-time.After(lockPollInterval):
+		}
+	}
+
+	// record the holder for processes waiting on the lock. Shared holders leave the file alone,
+	// as there may be several of them.
+	if !shared {
+		if err := f.Truncate(0); err == nil {
+			_, _ = f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+		}
+	}
+	logger.Debugf("acquired lock %s", filename)
+	return &fileLock{file: f, shared: shared}, nil
+}
+
+// Release releases the lock
+func (l *fileLock) Release() {
+	if l.file == nil {
+		return
+	}
+	if !l.shared {
+		_ = l.file.Truncate(0)
+	}
+	_ = unlock(l.file)
+	_ = l.file.Close()
+	l.file = nil
+}
+
+// acquireLocks takes the named locks exclusively in the given order. The returned function releases them.
+// Locks are always taken in the same order, so that processes never wait on each other:
+// the lock of a version, then the activation lock, then the prefix lock, then the shims lock.
+// The prefix lock is only held for short steps, without taking further locks.
+func (m *Manager) acquireLocks(names ...string) (func(), error) {
+	locks := make([]*fileLock, 0, len(names))
+	release := func() {
+		for i := len(locks) - 1; i >= 0; i-- {
+			locks[i].Release()
+		}
+	}
+	for _, name := range names {
+		lock, err := m.acquireLock(name, false)
+		if err != nil {
+			release()
+			return nil, err
+		}
+		locks = append(locks, lock)
+	}
+	return release, nil
+}
+
+// acquireSharedLock takes the named lock shared with other readers. The returned function releases it.
+// Readers of a prefix they cannot write to go on without the lock.
+func (m *Manager) acquireSharedLock(name string) (func(), error) {
+	lock, err := m.acquireLock(name, true)
+	if errors.Is(err, ErrLockTimeout) || m.context().Err() != nil {
+		return nil, err
+	} else if err != nil {
+		logger.Debugf("reading without the %s lock: %s", name, err)
+		return func() {}, nil
+	}
+	return lock.Release, nil
+}
@@ -0,0 +1,115 @@
+package pgo
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// installFakeVersion creates an installed version with a python that does nothing
+func installFakeVersion(t *testing.T, prefix string, versionStr string) {
+	t.Helper()
+	binDir := filepath.Join(prefix, versionsPath, versionStr, "bin")
+	if err := os.MkdirAll(binDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(binDir, "python"), []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUninstallWaitsForActivation(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no file locks on windows")
+	}
+	prefix := t.TempDir()
+	installFakeVersion(t, prefix, "3.11.9")
+	m := NewManager(Config{PPrefix: prefix, PLockTimeout: 300 * time.Millisecond}, ManagerOptions{})
+	if err := m.ActivatePythonVersion("3.11.9"); err != nil {
+		t.Fatal(err)
+	}
+
+	// another process is activating a version
+	release, err := m.acquireLocks(activateLock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.UninstallPythonVersion("3.11.9"); !errors.Is(err, ErrLockTimeout) {
+		t.Errorf("UninstallPythonVersion while activating = %v, want ErrLockTimeout", err)
+	}
+	if _, err := os.Stat(filepath.Join(prefix, versionsPath, "3.11.9")); err != nil {
+		t.Errorf("version removed while activating: %s", err)
+	}
+	release()
+
+	if err := m.UninstallPythonVersion("3.11.9"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.getActiveVersion(); !errors.Is(err, ErrNotActive) {
+		t.Errorf("active version after uninstalling it: %v, want ErrNotActive", err)
+	}
+}
+
+func TestAcquireLocksReleasesOnTimeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no file locks on windows")
+	}
+	m := NewManager(Config{PPrefix: t.TempDir(), PLockTimeout: 100 * time.Millisecond}, ManagerOptions{})
+	held, err := m.acquireLocks(activateLock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the version lock is released again when the activation lock cannot be taken
+	if _, err := m.acquireLocks(versionLock("3.12.1"), activateLock); !errors.Is(err, ErrLockTimeout) {
+		t.Fatalf("acquireLocks = %v, want ErrLockTimeout", err)
+	}
+	held()
+	release, err := m.acquireLocks(versionLock("3.12.1"))
+	if err != nil {
+		t.Fatalf("version lock still held: %s", err)
+	}
+	release()
+}
+
+func TestPrefixLockSharedByReaders(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no file locks on windows")
+	}
+	prefix := t.TempDir()
+	installFakeVersion(t, prefix, "3.12.1")
+	m := NewManager(Config{PPrefix: prefix, PLockTimeout: 300 * time.Millisecond}, ManagerOptions{})
+
+	// another process is listing the versions
+	release, err := m.acquireSharedLock(prefixLock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.GetInstalledVersions(); err != nil {
+		t.Errorf("GetInstalledVersions while another reader holds the prefix lock: %s", err)
+	}
+	if err := m.ActivatePythonVersion("3.12.1"); !errors.Is(err, ErrLockTimeout) {
+		t.Errorf("ActivatePythonVersion while reading = %v, want ErrLockTimeout", err)
+	}
+	release()
+
+	// another process is switching the active links
+	release, err = m.acquireLocks(prefixLock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.GetCurrentVersion(); !errors.Is(err, ErrLockTimeout) {
+		t.Errorf("GetCurrentVersion while activating = %v, want ErrLockTimeout", err)
+	}
+	release()
+
+	if err := m.ActivatePythonVersion("3.12.1"); err != nil {
+		t.Fatal(err)
+	}
+	if current, err := m.GetCurrentVersion(); err != nil || current != "3.12.1" {
+		t.Errorf("GetCurrentVersion = %q, %v, want 3.12.1", current, err)
+	}
+}
@@ -0,0 +1,26 @@
+//go:build !windows
+// +build !windows
+
+package pgo
+
+import (
+	"os"
+	"syscall"
+)
+
+// tryLockFile takes a flock(2) lock on the file without blocking, reporting whether it was taken
+func tryLockFile(f *os.File, shared bool) (bool, error) {
+	how := syscall.LOCK_EX
+	if shared {
+		how = syscall.LOCK_SH
+	}
+	err := syscall.Flock(int(f.Fd()), how|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func unlockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
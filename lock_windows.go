@@ -0,0 +1,17 @@
+//go:build windows
+// +build windows
+
+package pgo
+
+import (
+	"os"
+)
+
+// tryLockFile does not lock on windows, where installations are not supported yet
+func tryLockFile(f *os.File, shared bool) (bool, error) {
+	return true, nil
+}
+
+func unlockFile(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,166 @@
+package pgo
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// path after prefix where build logs will be stored
+	logsPath = "p/logs"
+	// number of lines of the build log shown when a build step fails
+	logTailLines = 40
+)
+
+// GetLogsDir returns the build log directory of the prefix set by P_PREFIX
+func GetLogsDir() string {
+	return defaultManager().GetLogsDir()
+}
+
+// GetLogsDir returns the directory build logs are written to
+func (m *Manager) GetLogsDir() string {
+	return filepath.Join(m.cfg.PPrefix, logsPath)
+}
+
+// newBuildLogPath returns the path of a new, timestamped build log for the version
+func (m *Manager) newBuildLogPath(versionStr string) (string, error) {
+	logsDir := m.GetLogsDir()
+	if err := m.fs.MkdirAll(logsDir, 0755); err != nil {
+		return "", err
+	}
+	filename := fmt.Sprintf("python-%s-%s.log", versionStr, time.Now().Format("20060102-150405"))
+	return filepath.Join(logsDir, filename), nil
+}
+
+// buildLogVersion returns the version a build log was written for
+func buildLogVersion(logFile string) string {
+	name := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(logFile), "python-"), ".log")
+	if idx := strings.Index(name, "-"); idx >= 0 {
+		return name[:idx]
+	}
+	return name
+}
+
+// tailFile returns the last n lines of a file
+func (m *Manager) tailFile(filename string, n int) (string, error) {
+	data, err := m.fs.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// BuildError is a failed step of a build from source
+type BuildError struct {
+	// Step is the command line of the step, e.g. "make -j8"
+	Step string
+	// LogPath is the build log, with the output of the step at its end
+	LogPath string
+	// ExitCode is the exit status of the step, -1 if it did not exit (e.g. it could not be started)
+	ExitCode int
+	// Err is the error of the CommandRunner
+	Err error
+	// tail is the end of the build log, shown in the message
+	tail string
+}
+
+func (e *BuildError) Error() string {
+	if e.tail == "" {
+		return fmt.Sprintf("`%s` failed: %s (build log: %s)", e.Step, e.Err, e.LogPath)
+	}
+	return fmt.Sprintf("`%s` failed: %s\n\n%s\n\nthe full build log is at %s", e.Step, e.Err, e.tail, e.LogPath)
+}
+
+func (e *BuildError) Unwrap() error {
+	return e.Err
+}
+
+// exitCode returns the exit status of a command from the error of a CommandRunner: an *exec.ExitError,
+// or any error with an ExitCode method. It is -1 for other errors.
+func exitCode(err error) int {
+	var coded interface{ ExitCode() int }
+	if errors.As(err, &coded) {
+		return coded.ExitCode()
+	}
+	return -1
+}
+
+// runBuildStep runs one step of a build in dir, appending its output to the build log.
+// If it fails, the error includes the end of the log and its path.
+func (m *Manager) runBuildStep(logFile string, dir string, env []string, name string, args ...string) error {
+	log, err := m.fs.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer log.Close()
+
+	cmdline := strings.TrimSpace(name + " " + strings.Join(args, " "))
+	logger.Infof("running `%s`", cmdline)
+	fmt.Fprintf(log, "==> %s: %s\n", time.Now().Format(time.RFC3339), cmdline)
+
+	cmd := Command{Name: name, Args: args, Dir: dir, Env: env, Stdout: log, Stderr: log}
+	if err := m.runner.Run(m.context(), cmd); err != nil {
+		if ctxErr := m.context().Err(); ctxErr != nil {
+			fmt.Fprintf(log, "==> `%s` interrupted: %s\n", cmdline, ctxErr)
+			return ctxErr
+		}
+		fmt.Fprintf(log, "==> `%s` failed: %s\n", cmdline, err)
+		buildErr := &BuildError{Step: cmdline, LogPath: logFile, ExitCode: exitCode(err), Err: err}
+		if tail, tailErr := m.tailFile(logFile, logTailLines); tailErr == nil {
+			buildErr.tail = tail
+		}
+		return buildErr
+	}
+	return nil
+}
+
+// GetBuildLogs lists the build logs in the prefix set by P_PREFIX for versions matching spec
+func GetBuildLogs(spec string) ([]string, error) {
+	return defaultManager().GetBuildLogs(spec)
+}
+
+// GetBuildLogs returns the paths of the build logs for versions matching spec
+// (all of them if spec is empty), oldest first
+func (m *Manager) GetBuildLogs(spec string) ([]string, error) {
+	files, err := m.fs.ReadDir(m.GetLogsDir())
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var constraints versionSpec
+	if spec != "" {
+		if constraints, err = parseVersionSpec(spec); err != nil {
+			return nil, err
+		}
+	}
+
+	// names end with a sortable timestamp, so sort by it rather than by version
+	sort.Slice(files, func(i, j int) bool {
+		return strings.TrimPrefix(files[i].Name(), "python-"+buildLogVersion(files[i].Name())) <
+			strings.TrimPrefix(files[j].Name(), "python-"+buildLogVersion(files[j].Name()))
+	})
+	logs := []string{}
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".log") {
+			continue
+		}
+		if constraints != nil {
+			if _, ok := constraints.newestMatching([]string{buildLogVersion(f.Name())}); !ok {
+				continue
+			}
+		}
+		logs = append(logs, filepath.Join(m.GetLogsDir(), f.Name()))
+	}
+	return logs, nil
+}
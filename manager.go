@@ -0,0 +1,264 @@
+package pgo
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+)
+
+// Manager manages the python versions of a prefix. Its zero value is not usable, use NewManager.
+// The package-level functions use a manager configured from the environment, see DefaultConfig.
+type Manager struct {
+	cfg    Config
+	client *http.Client
+	fs     FileSystem
+	runner CommandRunner
+	events EventHandler
+	// ctx stops downloads, builds and lock waits, see WithContext
+	ctx context.Context
+}
+
+// ManagerOptions provides a structure for the dependencies of a Manager. Nil fields get the defaults.
+type ManagerOptions struct {
+	// HTTPClient is used to talk to mirrors. The default trusts Config.PCABundle and honours HTTPS_PROXY / HTTP_PROXY.
+	HTTPClient *http.Client
+	// FS holds the prefix, the caches and the .python-version files. The default is the disk.
+	// The build steps run by Runner see the disk, so source builds need a file system backed by it,
+	// or a Runner that works on FS.
+	FS FileSystem
+	// Runner runs the build steps and the installed pythons. The default runs them with os/exec.
+	Runner CommandRunner
+	// Events receives the steps of installations, uninstallations and activations. The default drops them.
+	Events EventHandler
+}
+
+// NewManager returns a manager for the given configuration. Zero fields of cfg get the defaults documented
+// on Config, except PIndexTTL and POffline: a zero PIndexTTL downloads the version index every time.
+func NewManager(cfg Config, opts ManagerOptions) *Manager {
+	if cfg.PPrefix == "" {
+		cfg.PPrefix = os.Getenv("HOME")
+	}
+	if len(cfg.PMirrors) == 0 {
+		cfg.PMirrors = []string{defaultMirror}
+	}
+	if cfg.PKeyring == "" {
+		cfg.PKeyring = filepath.Join(cfg.PPrefix, keyringPath)
+	}
+	if cfg.PInstallFrom == "" {
+		cfg.PInstallFrom = installFromSource
+	}
+	if cfg.PLockTimeout == 0 {
+		cfg.PLockTimeout = defaultLockTimeout
+	}
+	if cfg.PReleaseStatusURL == "" {
+		cfg.PReleaseStatusURL = defaultReleaseStatusURL
+	}
+
+	m := &Manager{cfg: cfg, client: opts.HTTPClient, fs: opts.FS, runner: opts.Runner, events: opts.Events}
+	if m.fs == nil {
+		m.fs = osFS{}
+	}
+	if m.runner == nil {
+		m.runner = execRunner{}
+	}
+	return m
+}
+
+// DefaultConfig returns the configuration read from the environment (P_PREFIX, P_MIRROR, ...),
+// as used by the package-level functions
+func DefaultConfig() Config {
+	return getConfig()
+}
+
+// defaultManager returns the manager of the package-level functions. The environment is read
+// on every call, so that changes to it (e.g. --offline setting GOP_OFFLINE) are seen.
+func defaultManager() *Manager {
+	return NewManager(getConfig(), ManagerOptions{})
+}
+
+// Config returns the configuration of the manager, with the defaults filled in
+func (m *Manager) Config() Config {
+	return m.cfg
+}
+
+// withConfig returns a copy of the manager using cfg, sharing its dependencies
+func (m *Manager) withConfig(cfg Config) *Manager {
+	derived := *m
+	derived.cfg = cfg
+	return &derived
+}
+
+// WithContext returns a copy of the manager whose operations stop downloads, builds and waits for locks
+// held by other processes when ctx is done, returning ctx.Err() (possibly wrapped). Child processes
+// are killed along with the processes they started.
+func (m *Manager) WithContext(ctx context.Context) *Manager {
+	derived := *m
+	derived.ctx = ctx
+	return &derived
+}
+
+// context returns the context of the operation, never done unless given with WithContext
+func (m *Manager) context() context.Context {
+	if m.ctx == nil {
+		return context.Background()
+	}
+	return m.ctx
+}
+
+// httpClient returns the client given in ManagerOptions, or the default one for the configuration
+func (m *Manager) httpClient() (*http.Client, error) {
+	if m.client != nil {
+		return m.client, nil
+	}
+	return m.newHTTPClient()
+}
+
+// FileSystem is the file system a Manager reads and writes through. Its methods behave like
+// the functions of the same name in the os and io/ioutil packages.
+type FileSystem interface {
+	Stat(name string) (os.FileInfo, error)
+	Lstat(name string) (os.FileInfo, error)
+	ReadDir(dirname string) ([]os.FileInfo, error)
+	ReadFile(filename string) ([]byte, error)
+	WriteFile(filename string, data []byte, perm os.FileMode) error
+	OpenFile(name string, flag int, perm os.FileMode) (File, error)
+	MkdirAll(path string, perm os.FileMode) error
+	Remove(name string) error
+	RemoveAll(path string) error
+	Rename(oldpath, newpath string) error
+	Symlink(oldname, newname string) error
+	Readlink(name string) (string, error)
+	// Getwd returns the working directory, where .python-version files are looked up from
+	Getwd() (string, error)
+}
+
+// File is an open file of a FileSystem. Lock files are only locked against other processes if they are *os.File.
+type File interface {
+	io.Reader
+	io.Writer
+	io.WriterAt
+	io.Closer
+	Truncate(size int64) error
+}
+
+// osFS is the disk
+type osFS struct{}
+
+func (osFS) Stat(name string) (os.FileInfo, error)         { return os.Stat(name) }
+func (osFS) Lstat(name string) (os.FileInfo, error)        { return os.Lstat(name) }
+func (osFS) ReadDir(dirname string) ([]os.FileInfo, error) { return ioutil.ReadDir(dirname) }
+func (osFS) ReadFile(filename string) ([]byte, error)      { return ioutil.ReadFile(filename) }
+func (osFS) MkdirAll(path string, perm os.FileMode) error  { return os.MkdirAll(path, perm) }
+func (osFS) Remove(name string) error                      { return os.Remove(name) }
+func (osFS) RemoveAll(path string) error                   { return os.RemoveAll(path) }
+func (osFS) Rename(oldpath, newpath string) error          { return os.Rename(oldpath, newpath) }
+func (osFS) Symlink(oldname, newname string) error         { return os.Symlink(oldname, newname) }
+func (osFS) Readlink(name string) (string, error)          { return os.Readlink(name) }
+func (osFS) Getwd() (string, error)                        { return os.Getwd() }
+
+func (osFS) WriteFile(filename string, data []byte, perm os.FileMode) error {
+	return ioutil.WriteFile(filename, data, perm)
+}
+
+func (osFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	f, err := os.OpenFile(name, flag, perm)
+	if err != nil {
+		// a nil *os.File in a File interface would not compare equal to nil
+		return nil, err
+	}
+	return f, nil
+}
+
+// Command is an external command run by a Manager
+type Command struct {
+	Name string
+	Args []string
+	// Dir is the working directory, the current one if empty
+	Dir string
+	// Env is the environment, the current one if nil
+	Env    []string
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// CommandRunner runs the external commands of a Manager: configure and make, the C compiler
+// checking for headers, tar for zstd archives and the installed pythons
+type CommandRunner interface {
+	// Run runs the command to completion. A command exiting with a non-zero status is an error.
+	// When ctx is done, the command and the processes it started are killed and ctx.Err() is returned.
+	Run(ctx context.Context, cmd Command) error
+}
+
+// execRunner runs commands with os/exec, each in its own process group so that
+// make is killed along with the compilers it runs
+type execRunner struct{}
+
+// runningCommands holds the processes started by execRunner that have not exited yet,
+// so that their groups can be killed when gop exits without waiting for them
+var runningCommands = struct {
+	sync.Mutex
+	processes map[*os.Process]string
+}{processes: map[*os.Process]string{}}
+
+// killRunningCommands kills the process groups of the commands still running
+func killRunningCommands() {
+	runningCommands.Lock()
+	defer runningCommands.Unlock()
+	for process, name := range runningCommands.processes {
+		logger.Infof("killing `%s`", name)
+		_ = killProcessGroup(process)
+	}
+}
+
+func (execRunner) Run(ctx context.Context, c Command) error {
+	cmd := exec.Command(c.Name, c.Args...)
+	cmd.Dir = c.Dir
+	cmd.Env = c.Env
+	cmd.Stdin = c.Stdin
+	cmd.Stdout = c.Stdout
+	cmd.Stderr = c.Stderr
+	setProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	runningCommands.Lock()
+	runningCommands.processes[cmd.Process] = c.Name
+	runningCommands.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			logger.Infof("killing `%s`: %s", c.Name, ctx.Err())
+			_ = killProcessGroup(cmd.Process)
+		case <-done:
+		}
+	}()
+	err := cmd.Wait()
+	close(done)
+	runningCommands.Lock()
+	delete(runningCommands.processes, cmd.Process)
+	runningCommands.Unlock()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// output runs the command and returns what it wrote to stdout, and to stderr if combined is set
+func (m *Manager) output(cmd Command, combined bool) ([]byte, error) {
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	if combined {
+		cmd.Stderr = &out
+	}
+	err := m.runner.Run(m.context(), cmd)
+	return out.Bytes(), err
+}
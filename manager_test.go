@@ -0,0 +1,711 @@
+package pgo
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// memFS is a FileSystem in memory, with symbolic links
+type memFS struct {
+	mu    sync.Mutex
+	wd    string
+	nodes map[string]*memNode
+}
+
+type memNode struct {
+	data    []byte
+	mode    os.FileMode
+	link    string
+	modTime time.Time
+}
+
+func newMemFS(wd string) *memFS {
+	fs := &memFS{wd: wd, nodes: map[string]*memNode{"/": {mode: os.ModeDir | 0755}}}
+	if err := fs.MkdirAll(wd, 0755); err != nil {
+		panic(err)
+	}
+	return fs
+}
+
+// resolve returns the path of name with the links in it followed, except for the last element unless follow is set
+func (fs *memFS) resolve(name string, follow bool) string {
+	if !filepath.IsAbs(name) {
+		name = filepath.Join(fs.wd, name)
+	}
+	name = filepath.Clean(name)
+	for depth := 0; depth < 40; depth++ {
+		parts := strings.Split(strings.TrimPrefix(name, "/"), "/")
+		resolved := "/"
+		followed := false
+		for i, part := range parts {
+			next := filepath.Join(resolved, part)
+			n, ok := fs.nodes[next]
+			if !ok || n.mode&os.ModeSymlink == 0 || (i == len(parts)-1 && !follow) {
+				resolved = next
+				continue
+			}
+			target := n.link
+			if !filepath.IsAbs(target) {
+				target = filepath.Join(resolved, target)
+			}
+			name = filepath.Join(append([]string{target}, parts[i+1:]...)...)
+			followed = true
+			break
+		}
+		if !followed {
+			return resolved
+		}
+	}
+	return name
+}
+
+func (fs *memFS) lookup(op string, name string, follow bool) (string, *memNode, error) {
+	p := fs.resolve(name, follow)
+	n, ok := fs.nodes[p]
+	if !ok {
+		return p, nil, &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+	}
+	return p, n, nil
+}
+
+func (fs *memFS) children(dir string) []string {
+	names := []string{}
+	for p := range fs.nodes {
+		if p != dir && filepath.Dir(p) == dir {
+			names = append(names, filepath.Base(p))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+type memInfo struct {
+	name string
+	node memNode
+}
+
+func (fi memInfo) Name() string       { return fi.name }
+func (fi memInfo) Size() int64        { return int64(len(fi.node.data)) }
+func (fi memInfo) Mode() os.FileMode  { return fi.node.mode }
+func (fi memInfo) ModTime() time.Time { return fi.node.modTime }
+func (fi memInfo) IsDir() bool        { return fi.node.mode.IsDir() }
+func (fi memInfo) Sys() interface{}   { return nil }
+
+func (fs *memFS) stat(op string, name string, follow bool) (os.FileInfo, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	_, n, err := fs.lookup(op, name, follow)
+	if err != nil {
+		return nil, err
+	}
+	return memInfo{filepath.Base(name), *n}, nil
+}
+
+func (fs *memFS) Stat(name string) (os.FileInfo, error)  { return fs.stat("stat", name, true) }
+func (fs *memFS) Lstat(name string) (os.FileInfo, error) { return fs.stat("lstat", name, false) }
+func (fs *memFS) Getwd() (string, error)                 { return fs.wd, nil }
+
+func (fs *memFS) ReadDir(dirname string) ([]os.FileInfo, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	p, n, err := fs.lookup("open", dirname, true)
+	if err != nil {
+		return nil, err
+	}
+	if !n.mode.IsDir() {
+		return nil, &os.PathError{Op: "readdirent", Path: dirname, Err: syscall.ENOTDIR}
+	}
+	infos := []os.FileInfo{}
+	for _, name := range fs.children(p) {
+		infos = append(infos, memInfo{name, *fs.nodes[filepath.Join(p, name)]})
+	}
+	return infos, nil
+}
+
+func (fs *memFS) ReadFile(filename string) ([]byte, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	_, n, err := fs.lookup("open", filename, true)
+	if err != nil {
+		return nil, err
+	}
+	if n.mode.IsDir() {
+		return nil, &os.PathError{Op: "read", Path: filename, Err: syscall.EISDIR}
+	}
+	return append([]byte{}, n.data...), nil
+}
+
+func (fs *memFS) WriteFile(filename string, data []byte, perm os.FileMode) error {
+	f, err := fs.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// parentDir fails unless the directory name is to be created in exists
+func (fs *memFS) parentDir(op string, p string, name string) error {
+	if n, ok := fs.nodes[filepath.Dir(p)]; !ok {
+		return &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+	} else if !n.mode.IsDir() {
+		return &os.PathError{Op: op, Path: name, Err: syscall.ENOTDIR}
+	}
+	return nil
+}
+
+func (fs *memFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	p, n, err := fs.lookup("open", name, true)
+	if err != nil {
+		if flag&os.O_CREATE == 0 {
+			return nil, err
+		}
+		if err := fs.parentDir("open", p, name); err != nil {
+			return nil, err
+		}
+		n = &memNode{mode: perm, modTime: time.Now()}
+		fs.nodes[p] = n
+	} else if flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrExist}
+	}
+	if n.mode.IsDir() && flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EISDIR}
+	}
+	if flag&os.O_TRUNC != 0 {
+		n.data = nil
+	}
+	return &memFile{fs: fs, node: n, append: flag&os.O_APPEND != 0}, nil
+}
+
+func (fs *memFS) MkdirAll(path string, perm os.FileMode) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	p := fs.resolve(path, true)
+	dir := "/"
+	for _, part := range strings.Split(strings.TrimPrefix(p, "/"), "/") {
+		dir = filepath.Join(dir, part)
+		if n, ok := fs.nodes[fs.resolve(dir, true)]; ok {
+			if !n.mode.IsDir() {
+				return &os.PathError{Op: "mkdir", Path: path, Err: syscall.ENOTDIR}
+			}
+			continue
+		}
+		fs.nodes[dir] = &memNode{mode: os.ModeDir | perm, modTime: time.Now()}
+	}
+	return nil
+}
+
+func (fs *memFS) Remove(name string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	p, n, err := fs.lookup("remove", name, false)
+	if err != nil {
+		return err
+	}
+	if n.mode.IsDir() && len(fs.children(p)) > 0 {
+		return &os.PathError{Op: "remove", Path: name, Err: syscall.ENOTEMPTY}
+	}
+	delete(fs.nodes, p)
+	return nil
+}
+
+func (fs *memFS) RemoveAll(path string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	p := fs.resolve(path, false)
+	for name := range fs.nodes {
+		if name == p || strings.HasPrefix(name, p+"/") {
+			delete(fs.nodes, name)
+		}
+	}
+	return nil
+}
+
+func (fs *memFS) Rename(oldpath, newpath string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	oldP, _, err := fs.lookup("rename", oldpath, false)
+	if err != nil {
+		return err
+	}
+	newP := fs.resolve(newpath, false)
+	if err := fs.parentDir("rename", newP, newpath); err != nil {
+		return err
+	}
+	if n, ok := fs.nodes[newP]; ok && n.mode.IsDir() && len(fs.children(newP)) > 0 {
+		return &os.PathError{Op: "rename", Path: newpath, Err: syscall.ENOTEMPTY}
+	}
+	moved := map[string]*memNode{}
+	for name, n := range fs.nodes {
+		if name == oldP || strings.HasPrefix(name, oldP+"/") {
+			moved[newP+strings.TrimPrefix(name, oldP)] = n
+			delete(fs.nodes, name)
+		}
+	}
+	for name, n := range moved {
+		fs.nodes[name] = n
+	}
+	return nil
+}
+
+func (fs *memFS) Symlink(oldname, newname string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	p := fs.resolve(newname, false)
+	if _, ok := fs.nodes[p]; ok {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: os.ErrExist}
+	}
+	if err := fs.parentDir("symlink", p, newname); err != nil {
+		return err
+	}
+	fs.nodes[p] = &memNode{mode: os.ModeSymlink | 0777, link: oldname, modTime: time.Now()}
+	return nil
+}
+
+func (fs *memFS) Readlink(name string) (string, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	_, n, err := fs.lookup("readlink", name, false)
+	if err != nil {
+		return "", err
+	}
+	if n.mode&os.ModeSymlink == 0 {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: syscall.EINVAL}
+	}
+	return n.link, nil
+}
+
+// memFile is an open file of a memFS
+type memFile struct {
+	fs     *memFS
+	node   *memNode
+	offset int64
+	append bool
+}
+
+func (f *memFile) Read(b []byte) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if f.offset >= int64(len(f.node.data)) {
+		return 0, io.EOF
+	}
+	n := copy(b, f.node.data[f.offset:])
+	f.offset += int64(n)
+	return n, nil
+}
+
+func (f *memFile) Write(b []byte) (int, error) {
+	if f.append {
+		f.offset = int64(len(f.node.data))
+	}
+	n, err := f.WriteAt(b, f.offset)
+	f.offset += int64(n)
+	return n, err
+}
+
+func (f *memFile) WriteAt(b []byte, off int64) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if end := off + int64(len(b)); end > int64(len(f.node.data)) {
+		f.node.data = append(f.node.data, make([]byte, end-int64(len(f.node.data)))...)
+	}
+	copy(f.node.data[off:], b)
+	f.node.modTime = time.Now()
+	return len(b), nil
+}
+
+func (f *memFile) Truncate(size int64) error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if size < int64(len(f.node.data)) {
+		f.node.data = f.node.data[:size]
+	} else {
+		f.node.data = append(f.node.data, make([]byte, size-int64(len(f.node.data)))...)
+	}
+	return nil
+}
+
+func (f *memFile) Close() error { return nil }
+
+// fakeBuild is a CommandRunner building python without compiling anything: make install
+// puts a python in DESTDIR that reports the configured version
+type fakeBuild struct {
+	fs      *memFS
+	version string
+
+	mu       sync.Mutex
+	prefix   string
+	commands []string
+}
+
+func (b *fakeBuild) Run(ctx context.Context, cmd Command) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.commands = append(b.commands, strings.TrimSpace(cmd.Name+" "+strings.Join(cmd.Args, " ")))
+	stdout := cmd.Stdout
+	if stdout == nil {
+		stdout = ioutil.Discard
+	}
+
+	switch {
+	case cmd.Name == "cc":
+		return nil
+	case cmd.Name == "./configure":
+		if _, err := b.fs.Stat(filepath.Join(cmd.Dir, "configure")); err != nil {
+			return err
+		}
+		for _, arg := range cmd.Args {
+			if strings.HasPrefix(arg, "--prefix=") {
+				b.prefix = strings.TrimPrefix(arg, "--prefix=")
+			}
+		}
+		fmt.Fprintln(stdout, "checking for a fake compiler... yes")
+		return nil
+	case cmd.Name == "make" && len(cmd.Args) == 2 && cmd.Args[0] == "install":
+		binDir := filepath.Join(strings.TrimPrefix(cmd.Args[1], "DESTDIR="), b.prefix, "bin")
+		if err := b.fs.MkdirAll(binDir, 0755); err != nil {
+			return err
+		}
+		return b.fs.WriteFile(filepath.Join(binDir, "python3"), []byte("#!/bin/sh\n"), 0755)
+	case cmd.Name == "make":
+		return nil
+	case filepath.Base(cmd.Name) == "python" && len(cmd.Args) > 0:
+		if cmd.Args[0] == "--version" {
+			fmt.Fprintf(stdout, "Python %s\n", b.version)
+		}
+		// with -c, no module is missing
+		return nil
+	}
+	return fmt.Errorf("unexpected command %s", cmd.Name)
+}
+
+// writeMirrorRelease puts a source tarball of the version, and its checksum, in the mirror directory
+func writeMirrorRelease(t *testing.T, fs *memFS, mirrorDir string, versionStr string) {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	srcDir := "Python-" + versionStr
+	files := []struct {
+		name string
+		mode int64
+		body string
+	}{
+		{srcDir + "/", 0755, ""},
+		{srcDir + "/configure", 0755, "#!/bin/sh\n"},
+		{srcDir + "/README.rst", 0644, "This is Python version " + versionStr + "\n"},
+	}
+	for _, file := range files {
+		hdr := &tar.Header{Name: file.name, Mode: file.mode, Size: int64(len(file.body)), Typeflag: tar.TypeReg}
+		if strings.HasSuffix(file.name, "/") {
+			hdr.Typeflag = tar.TypeDir
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(file.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	releaseDir := filepath.Join(mirrorDir, getReleaseDir(versionStr))
+	tarball := filepath.Join(releaseDir, srcDir+".tgz")
+	sum := sha256.Sum256(buf.Bytes())
+	if err := fs.MkdirAll(releaseDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.WriteFile(tarball, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.WriteFile(tarball+".sha256", []byte(hex.EncodeToString(sum[:])+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newMemManager returns a manager of the prefix /home/user in memory, with a local mirror at /mirror/
+func newMemManager(t *testing.T, versionStr string) (*Manager, *memFS, *fakeBuild) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("memFS only handles slash-separated paths")
+	}
+	// the build checks for a compiler and make before running anything
+	binDir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(binDir, "make"), []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", binDir)
+	t.Setenv("CC", "cc")
+
+	fs := newMemFS("/home/user/project")
+	runner := &fakeBuild{fs: fs, version: versionStr}
+	cfg := Config{PPrefix: "/home/user", PMirrors: []string{"file:///mirror/"}}
+	return NewManager(cfg, ManagerOptions{FS: fs, Runner: runner}), fs, runner
+}
+
+func TestInstallInMemory(t *testing.T) {
+	m, fs, runner := newMemManager(t, "3.12.1")
+	writeMirrorRelease(t, fs, "/mirror", "3.12.1")
+
+	if err := m.InstallPythonVersion("3.12.1", false); err != nil {
+		t.Fatal(err)
+	}
+	versionDir := filepath.Join("/home/user", versionsPath, "3.12.1")
+	want := []string{
+		"./configure --prefix=" + versionDir,
+		"make -j",
+		"make install DESTDIR=",
+		filepath.Join(versionDir, "bin", "python") + " --version",
+	}
+	got := strings.Join(runner.commands, "\n")
+	for _, step := range want {
+		if !strings.Contains(got, step) {
+			t.Errorf("no `%s` in the commands run:\n%s", step, got)
+		}
+	}
+
+	installed, err := m.GetInstalledVersions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(installed) != 1 || installed[0] != "3.12.1" {
+		t.Errorf("installed versions = %v, want [3.12.1]", installed)
+	}
+	info, err := m.GetBuildInfo("3.12.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Source != "file:///mirror/3.12.1/Python-3.12.1.tgz" {
+		t.Errorf("build info source = %s", info.Source)
+	}
+	// the staging directory and the downloaded tarball are gone
+	if entries, err := fs.ReadDir(filepath.Join("/home/user", stagingPath)); err != nil || len(entries) != 0 {
+		t.Errorf("staging directory after the installation: %v, %v", entries, err)
+	}
+	if _, err := fs.Stat(filepath.Join("/home/user", versionsPath, "temp", "Python-3.12.1.tgz")); !os.IsNotExist(err) {
+		t.Errorf("tarball left behind: %v", err)
+	}
+
+	if err := m.InstallPythonVersion("3.12.1", false); !errors.Is(err, ErrAlreadyInstalled) {
+		t.Errorf("installing again = %v, want ErrAlreadyInstalled", err)
+	}
+}
+
+func TestInstallRecordsMirrorInMemory(t *testing.T) {
+	m, fs, _ := newMemManager(t, "3.12.1")
+	cfg := m.Config()
+	cfg.PMirrors = []string{"file:///empty/", "file:///mirror/"}
+	m = m.withConfig(cfg)
+	writeMirrorRelease(t, fs, "/mirror", "3.12.1")
+	tarball := "/mirror/3.12.1/Python-3.12.1.tgz"
+	cached := filepath.Join("/home/user", versionsPath, "temp", "Python-3.12.1.tgz")
+	installedFrom := func() string {
+		t.Helper()
+		info, err := m.GetBuildInfo("3.12.1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return info.Source
+	}
+
+	// the first mirror does not have it
+	if err := m.InstallPythonVersion("3.12.1", false); err != nil {
+		t.Fatal(err)
+	}
+	if got := installedFrom(); got != "file://"+tarball {
+		t.Errorf("installed from %s, want the second mirror", got)
+	}
+
+	// a tarball downloaded by an earlier attempt is used, and recorded as coming from where it was downloaded
+	data, err := fs.ReadFile(tarball)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.WriteFile(cached, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.WriteFile(cached+originSuffix, []byte("file://"+tarball+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Remove(tarball); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.InstallPythonVersion("3.12.1", true); err != nil {
+		t.Fatal(err)
+	}
+	if got := installedFrom(); got != "file://"+tarball {
+		t.Errorf("installed from %s, want the mirror the cached tarball came from", got)
+	}
+	if _, err := fs.Stat(cached + originSuffix); !os.IsNotExist(err) {
+		t.Errorf("origin of the tarball left behind: %v", err)
+	}
+
+	// one of unknown origin is downloaded again
+	if err := fs.WriteFile(tarball, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.WriteFile(cached, []byte("not a tarball"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.InstallPythonVersion("3.12.1", true); err != nil {
+		t.Fatal(err)
+	}
+	if got := installedFrom(); got != "file://"+tarball {
+		t.Errorf("installed from %s, want the second mirror", got)
+	}
+}
+
+func TestActivateInMemory(t *testing.T) {
+	m, fs, _ := newMemManager(t, "3.12.1")
+	for _, vstr := range []string{"3.11.9", "3.12.1"} {
+		binDir := filepath.Join("/home/user", versionsPath, vstr, "bin")
+		if err := fs.MkdirAll(binDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := fs.WriteFile(filepath.Join(binDir, "python"), []byte(vstr), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	activePython := filepath.Join("/home/user", activePath, "bin", "python")
+
+	for _, vstr := range []string{"3.11.9", "3.12.1"} {
+		if err := m.ActivatePythonVersion(vstr); err != nil {
+			t.Fatal(err)
+		}
+		if current, err := m.GetCurrentVersion(); err != nil || current != vstr {
+			t.Errorf("current version = %q, %v, want %s", current, err, vstr)
+		}
+		if data, err := fs.ReadFile(activePython); err != nil || string(data) != vstr {
+			t.Errorf("active python = %q, %v, want the one of %s", data, err, vstr)
+		}
+	}
+
+	if err := m.ActivatePythonVersion("3.10.1"); !errors.Is(err, ErrNotInstalled) {
+		t.Errorf("activating a version that is not installed = %v, want ErrNotInstalled", err)
+	}
+	if err := m.Deactivate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.GetCurrentVersion(); !errors.Is(err, ErrNotActive) {
+		t.Errorf("current version after deactivating: %v, want ErrNotActive", err)
+	}
+	if _, err := fs.Stat(activePython); !os.IsNotExist(err) {
+		t.Errorf("active python after deactivating: %v", err)
+	}
+}
+
+func TestResolveVersionInMemory(t *testing.T) {
+	m, fs, _ := newMemManager(t, "3.12.1")
+	for _, vstr := range []string{"3.11.2", "3.11.9", "3.12.0rc1", "3.12.1"} {
+		writeMirrorRelease(t, fs, "/mirror", vstr)
+	}
+	binDir := filepath.Join("/home/user", versionsPath, "3.11.2", "bin")
+	if err := fs.MkdirAll(binDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.WriteFile(filepath.Join(binDir, "python"), nil, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		spec string
+		want string
+	}{
+		{"3.12", "3.12.1"},
+		{"3", "3.11.2"},
+		{"3.11", "3.11.2"},
+		{"~3.11.3", "3.11.9"},
+		{"3.12.0rc1", "3.12.0rc1"},
+	}
+	for _, test := range tests {
+		if got, err := m.ResolveVersion(test.spec); err != nil || got != test.want {
+			t.Errorf("ResolveVersion(%q) = %q, %v, want %s", test.spec, got, err, test.want)
+		}
+	}
+	if got, err := m.ResolveVersion("3.13"); err == nil {
+		t.Errorf("ResolveVersion(3.13) = %s, want an error", got)
+	}
+
+	// installing looks at the mirror only
+	if got, err := m.ResolveVersionWithOptions("3.11", ResolveOptions{Available: true}); err != nil || got != "3.11.9" {
+		t.Errorf("ResolveVersionWithOptions(3.11, Available) = %q, %v, want 3.11.9", got, err)
+	}
+}
+
+func TestExecRunnerKillsGrandchildren(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("only the process itself is killed on windows")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pr, pw := io.Pipe()
+	defer pr.Close()
+
+	// the shell starts sleep and waits for it, like make waiting for a compiler
+	done := make(chan error, 1)
+	go func() {
+		cmd := Command{Name: "sh", Args: []string{"-c", "sleep 60 & echo started; wait"}, Stdout: pw}
+		done <- execRunner{}.Run(ctx, cmd)
+		pw.Close()
+	}()
+	line, err := bufio.NewReader(pr).ReadString('\n')
+	if err != nil || line != "started\n" {
+		t.Fatalf("output of the command: %q, %v", line, err)
+	}
+	go io.Copy(ioutil.Discard, pr)
+	cancel()
+
+	// sleep holds the output of the command open: Run only returns once it is killed too
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Run = %v, want context.Canceled", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("the grandchild of the command was not killed")
+	}
+}
+
+func TestWithContextStopsInstall(t *testing.T) {
+	m, fs, runner := newMemManager(t, "3.12.1")
+	writeMirrorRelease(t, fs, "/mirror", "3.12.1")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := m.WithContext(ctx).InstallPythonVersion("3.12.1", false); !errors.Is(err, context.Canceled) {
+		t.Errorf("InstallPythonVersion with a cancelled context = %v, want context.Canceled", err)
+	}
+	for _, command := range runner.commands {
+		if strings.HasPrefix(command, "./configure") || strings.HasPrefix(command, "make") {
+			t.Errorf("built after the context was cancelled: %s", command)
+		}
+	}
+}
@@ -0,0 +1,397 @@
+package pgo
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// names of source tarballs and Windows installers in a release directory
+var reInstallerFile = regexp.MustCompile(`^(?i:python)-(.+?)(?:\.tgz|\.amd64\.msi)$`)
+
+// mirrorError lists the mirrors that were tried and why each of them failed
+type mirrorError struct {
+	what  string
+	tried []string
+	errs  []error
+}
+
+func (e *mirrorError) add(location string, err error) {
+	e.tried = append(e.tried, location)
+	e.errs = append(e.errs, err)
+}
+
+func (e *mirrorError) Error() string {
+	lines := []string{fmt.Sprintf("unable to get %s, tried %d mirror(s):", e.what, len(e.tried))}
+	for i, location := range e.tried {
+		// download errors start with the location already
+		var downloadErr *DownloadError
+		if errors.As(e.errs[i], &downloadErr) && downloadErr.URL == location {
+			lines = append(lines, "  "+e.errs[i].Error())
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("  %s: %s", location, e.errs[i]))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Unwrap returns the error of each mirror, so that errors.Is and errors.As look into them
+func (e *mirrorError) Unwrap() []error {
+	return e.errs
+}
+
+// splitMirrors splits the comma or newline separated list of mirrors given in P_MIRROR,
+// making sure each of them ends with a slash. Spaces around the mirrors are ignored, but not
+// within them: they may be directories with spaces in their name.
+func splitMirrors(mirrors string) []string {
+	fields := strings.FieldsFunc(mirrors, func(r rune) bool {
+		return r == ',' || r == '\n'
+	})
+	result := make([]string, 0, len(fields))
+	for _, mirror := range fields {
+		mirror = strings.TrimSpace(mirror)
+		if mirror == "" {
+			continue
+		}
+		if !strings.HasSuffix(mirror, "/") {
+			mirror += "/"
+		}
+		result = append(result, mirror)
+	}
+	return result
+}
+
+// localMirrorPath returns the path of a file:// URL or a plain path, and false for remote URLs
+func localMirrorPath(location string) (string, bool) {
+	if strings.HasPrefix(location, "file://") {
+		u, err := url.Parse(location)
+		if err != nil {
+			return "", false
+		}
+		return filepath.FromSlash(u.Path), true
+	}
+	if strings.Contains(location, "://") {
+		return "", false
+	}
+	return filepath.FromSlash(location), true
+}
+
+// localMirrors returns the mirrors that are directories on disk, the only ones usable offline
+func localMirrors(mirrors []string) []string {
+	local := []string{}
+	for _, mirror := range mirrors {
+		if _, ok := localMirrorPath(mirror); ok {
+			local = append(local, mirror)
+		}
+	}
+	return local
+}
+
+// remoteMirrors returns the mirrors that have to be downloaded from
+func remoteMirrors(mirrors []string) []string {
+	remote := []string{}
+	for _, mirror := range mirrors {
+		if _, ok := localMirrorPath(mirror); !ok {
+			remote = append(remote, mirror)
+		}
+	}
+	return remote
+}
+
+// listLocalVersions returns the versions in a mirror directory laid out like python.org:
+// one directory per release, holding the tarballs of the release and its pre-releases.
+// Like on remote mirrors, a release is only listed once its own tarball is there.
+func (m *Manager) listLocalVersions(mirrorDir string) ([]pyVersion, error) {
+	entries, err := m.fs.ReadDir(mirrorDir)
+	if err != nil {
+		return nil, err
+	}
+	pyVers := newPyVersionOrderedSet()
+	for _, entry := range entries {
+		if !entry.IsDir() || !reReleaseDir.MatchString(entry.Name()+"/") {
+			continue
+		}
+		release, err := parsePyVersion(entry.Name())
+		if err != nil {
+			continue
+		}
+		files, err := m.fs.ReadDir(filepath.Join(mirrorDir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		names := make([]string, 0, len(files))
+		for _, file := range files {
+			names = append(names, file.Name())
+		}
+		for _, pver := range releaseDirVersions(release, names) {
+			_ = pyVers.Add(pver.String())
+		}
+	}
+	return pyVers.AsSlice(), nil
+}
+
+// redactURL removes the password from a URL, so it can be logged
+func redactURL(location string) string {
+	u, err := url.Parse(location)
+	if err != nil || u.User == nil {
+		return location
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), "xxxxx")
+	}
+	return u.String()
+}
+
+// readNetrc returns the login and password for host from a netrc file,
+// falling back to its default entry
+func (m *Manager) readNetrc(netrcFile string, host string) (string, string, bool) {
+	data, err := m.fs.ReadFile(netrcFile)
+	if err != nil {
+		return "", "", false
+	}
+
+	type entry struct{ login, password string }
+	entries := map[string]*entry{}
+	var current *entry
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	scanner.Split(bufio.ScanWords)
+	for scanner.Scan() {
+		switch scanner.Text() {
+		case "machine":
+			if !scanner.Scan() {
+				break
+			}
+			current = &entry{}
+			entries[scanner.Text()] = current
+		case "default":
+			current = &entry{}
+			entries[""] = current
+		case "login":
+			if scanner.Scan() && current != nil {
+				current.login = scanner.Text()
+			}
+		case "password":
+			if scanner.Scan() && current != nil {
+				current.password = scanner.Text()
+			}
+		case "macdef":
+			// macros are not used, and end at an empty line the word scanner cannot see
+			current = nil
+		}
+	}
+
+	if e, ok := entries[host]; ok {
+		return e.login, e.password, true
+	}
+	if e, ok := entries[""]; ok {
+		return e.login, e.password, true
+	}
+	return "", "", false
+}
+
+// setMirrorAuth adds credentials to a request: the P_MIRROR_TOKEN or P_MIRROR_USER and P_MIRROR_PASSWORD
+// environment variables for the host of the first mirror, or the netrc entry of the request's host.
+// Credentials in the URL itself are used by net/http if no others are found.
+func (m *Manager) setMirrorAuth(req *http.Request) {
+	if len(m.cfg.PMirrors) > 0 {
+		if primary, err := url.Parse(m.cfg.PMirrors[0]); err == nil && primary.Host == req.URL.Host {
+			if m.cfg.PMirrorToken != "" {
+				req.Header.Set("Authorization", "Bearer "+m.cfg.PMirrorToken)
+				return
+			}
+			if m.cfg.PMirrorUser != "" {
+				req.SetBasicAuth(m.cfg.PMirrorUser, m.cfg.PMirrorPassword)
+				return
+			}
+		}
+	}
+	if req.URL.User != nil {
+		return
+	}
+	if login, password, ok := m.readNetrc(m.cfg.PNetrc, req.URL.Hostname()); ok {
+		req.SetBasicAuth(login, password)
+	}
+}
+
+// newHTTPClient returns the default client used to talk to mirrors. It trusts the CA bundle given in
+// P_CA_BUNDLE in addition to the system roots, and goes through HTTPS_PROXY / HTTP_PROXY,
+// unless the host is listed in NO_PROXY.
+func (m *Manager) newHTTPClient() (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = func(req *http.Request) (*url.URL, error) {
+		proxy, err := http.ProxyFromEnvironment(req)
+		if proxy != nil {
+			logger.Debugf("using proxy %s for %s", redactURL(proxy.String()), req.URL.Host)
+		}
+		return proxy, err
+	}
+
+	if m.cfg.PCABundle != "" {
+		pem, err := m.fs.ReadFile(m.cfg.PCABundle)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read CA bundle: %w", err)
+		}
+		roots, err := x509.SystemCertPool()
+		if err != nil || roots == nil {
+			roots = x509.NewCertPool()
+		}
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", m.cfg.PCABundle)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: roots}
+	}
+	return &http.Client{Transport: transport}, nil
+}
+
+// httpGet requests the URL with the mirror credentials, failing on any status but 200 OK.
+// The caller closes the body of the response.
+func (m *Manager) httpGet(location string) (*http.Response, error) {
+	return m.httpGetFrom(location, 0)
+}
+
+// httpGetFrom requests the URL from the given byte offset on. The response is either
+// 200 OK with the whole body, or 206 Partial Content if the server could resume at offset.
+func (m *Manager) httpGetFrom(location string, offset int64) (*http.Response, error) {
+	client, err := m.httpClient()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodGet, location, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(m.context())
+	m.setMirrorAuth(req)
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		// the URL is in DownloadError, redacted
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return nil, &DownloadError{URL: redactURL(location), Err: err}
+	}
+	if resp.StatusCode != http.StatusOK && (offset == 0 || resp.StatusCode != http.StatusPartialContent) {
+		resp.Body.Close()
+		return nil, &DownloadError{URL: redactURL(location), Status: resp.StatusCode}
+	}
+	return resp, nil
+}
+
+// defaultNetrc returns the netrc file read for mirror credentials: $NETRC, or .netrc in the home directory
+func defaultNetrc() string {
+	if netrc := os.Getenv("NETRC"); netrc != "" {
+		return netrc
+	}
+	return filepath.Join(os.Getenv("HOME"), ".netrc")
+}
+
+// SyncMirror copies the installers matching spec from the mirrors of P_MIRROR to dir
+func SyncMirror(dir string, spec string) ([]string, error) {
+	return defaultManager().SyncMirror(dir, spec)
+}
+
+// SyncMirror downloads the source tarballs (and their sigstore bundles and signatures) of the versions matching spec from
+// the remote mirrors into dir, laid out like python.org so that dir can be used as P_MIRROR on hosts
+// without internet access. Files already in dir are kept. It returns the versions synced.
+func (m *Manager) SyncMirror(dir string, spec string) ([]string, error) {
+	constraints, err := parseVersionSpec(spec)
+	if err != nil {
+		return nil, err
+	}
+	cfg := m.cfg
+	cfg.PMirrors = remoteMirrors(cfg.PMirrors)
+	if len(cfg.PMirrors) == 0 {
+		return nil, fmt.Errorf("no remote mirror to sync from, P_MIRROR only lists local directories")
+	}
+	if cfg.POffline {
+		return nil, fmt.Errorf("%w: syncing a mirror requires downloads", ErrOffline)
+	}
+	m = m.withConfig(cfg)
+
+	minVersion, err := parsePyVersion(minLegalVersion)
+	if err != nil {
+		return nil, err
+	}
+	available, err := m.getIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	synced := []string{}
+	for _, pver := range available {
+		if pver.Compare(minVersion) < 0 || !constraints.matches(pver) {
+			continue
+		}
+		vstr := pver.String()
+		releaseDir := filepath.Join(dir, getReleaseDir(vstr))
+		if err := m.fs.MkdirAll(releaseDir, 0755); err != nil {
+			return synced, err
+		}
+
+		tarballURLs := []string{}
+		for _, mirror := range m.cfg.PMirrors {
+			tarballURLs = append(tarballURLs, getPythonInstallerURLUnix(mirror, vstr))
+		}
+		if err := m.syncFile(tarballURLs, releaseDir); err != nil {
+			return synced, err
+		}
+
+		for _, ext := range []string{".sigstore", ".asc"} {
+			signatureURLs := []string{}
+			for _, tarballURL := range tarballURLs {
+				signatureURLs = append(signatureURLs, tarballURL+ext)
+			}
+			if err := m.syncFile(signatureURLs, releaseDir); err != nil {
+				logger.Warningf("no %s signature synced for %s: %s", ext, vstr, err)
+			}
+		}
+
+		logger.Infof("synced %s into %s", vstr, releaseDir)
+		synced = append(synced, vstr)
+	}
+	if len(synced) == 0 {
+		candidates := make([]string, 0, len(available))
+		for _, pver := range available {
+			candidates = append(candidates, pver.String())
+		}
+		return nil, &VersionNotFoundError{Spec: spec, Candidates: candidates}
+	}
+	return synced, nil
+}
+
+// syncFile downloads a file into the mirror directory dir from the first of the given URLs that works.
+// Unlike installers, a file already in dir is left alone and nothing else is written next to it,
+// so that the directory stays a plain copy of the mirror.
+func (m *Manager) syncFile(fileURLs []string, dir string) error {
+	targetFile := filepath.Join(dir, path.Base(fileURLs[0]))
+	if _, err := m.fs.Stat(targetFile); err == nil {
+		logger.Debugf("%s is already synced", targetFile)
+		return nil
+	}
+	mirrorErr := &mirrorError{what: path.Base(targetFile)}
+	for _, fileURL := range fileURLs {
+		err := m.downloadFile(fileURL, targetFile)
+		if err == nil {
+			return nil
+		}
+		if m.context().Err() != nil {
+			return err
+		}
+		logger.Warningf("unable to download %s: %s", redactURL(fileURL), err)
+		mirrorErr.add(redactURL(fileURL), err)
+	}
+	return mirrorErr
+}
@@ -0,0 +1,121 @@
+package pgo
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSplitMirrors(t *testing.T) {
+	tests := []struct {
+		mirrors string
+		want    []string
+	}{
+		{"", []string{}},
+		{"https://a.example.com/python", []string{"https://a.example.com/python/"}},
+		{"https://a.example.com/python/, https://www.python.org/ftp/python/", []string{"https://a.example.com/python/", "https://www.python.org/ftp/python/"}},
+		{"/srv/python mirror\n/mnt/other mirror/\n", []string{"/srv/python mirror/", "/mnt/other mirror/"}},
+	}
+	for _, test := range tests {
+		if got := splitMirrors(test.mirrors); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("splitMirrors(%q) = %q, want %q", test.mirrors, got, test.want)
+		}
+	}
+}
+
+func TestListLocalVersions(t *testing.T) {
+	dir := t.TempDir()
+	for _, file := range []string{
+		"3.11.9/Python-3.11.9.tgz",
+		"3.12.0/Python-3.12.0rc3.tgz",
+		"3.12.0/Python-3.12.0.tgz",
+		// only release candidates so far
+		"3.13.0/Python-3.13.0rc1.tgz",
+		"3.13.0/Python-3.13.0rc1.tgz.asc",
+		// a synced signature without its tarball
+		"3.12.1/Python-3.12.1.tgz.sigstore",
+		"notes/Python-3.10.0.tgz",
+	} {
+		path := filepath.Join(dir, filepath.FromSlash(file))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "3.14.0"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewManager(Config{PPrefix: t.TempDir()}, ManagerOptions{})
+	versions, err := m.listLocalVersions(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"3.11.9", "3.12.0rc3", "3.12.0", "3.13.0rc1"}
+	if got := versionStrings(versions); !reflect.DeepEqual(got, want) {
+		t.Errorf("listLocalVersions = %v, want %v", got, want)
+	}
+}
+
+func TestSyncMirrorKeepsExistingFiles(t *testing.T) {
+	listings := &listingServer{dirs: map[string][]string{
+		"/":        {"3.12.1/"},
+		"/3.12.1/": {"Python-3.12.1.tgz", "Python-3.12.1.tgz.sigstore"},
+	}}
+	files := map[string]string{
+		"/3.12.1/Python-3.12.1.tgz":          "tarball",
+		"/3.12.1/Python-3.12.1.tgz.sigstore": "bundle",
+	}
+	downloads := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if body, ok := files[r.URL.Path]; ok {
+			downloads++
+			w.Write([]byte(body))
+			return
+		}
+		listings.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+	dir := t.TempDir()
+	m := NewManager(Config{PPrefix: t.TempDir(), PMirrors: []string{ts.URL + "/"}}, ManagerOptions{HTTPClient: ts.Client()})
+
+	if _, err := m.SyncMirror(dir, "3.12"); err != nil {
+		t.Fatal(err)
+	}
+	// a file changed in the directory since is not replaced
+	tarball := filepath.Join(dir, "3.12.1", "Python-3.12.1.tgz")
+	if err := ioutil.WriteFile(tarball, []byte("local"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	downloads = 0
+	synced, err := m.SyncMirror(dir, "3.12")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"3.12.1"}; !reflect.DeepEqual(synced, want) {
+		t.Errorf("SyncMirror = %v, want %v", synced, want)
+	}
+	if downloads != 0 {
+		t.Errorf("the second sync downloaded %d files", downloads)
+	}
+	if data, _ := ioutil.ReadFile(tarball); string(data) != "local" {
+		t.Errorf("the tarball was replaced by %q", data)
+	}
+	entries, err := ioutil.ReadDir(filepath.Join(dir, "3.12.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	if want := []string{"Python-3.12.1.tgz", "Python-3.12.1.tgz.sigstore"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("the mirror directory holds %v, want %v", names, want)
+	}
+}
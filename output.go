@@ -0,0 +1,180 @@
+package pgo
+
+import (
+	"os"
+	"time"
+)
+
+// OutputSchemaVersion is the version of the documents printed with --json, given in their "schema" field.
+// Fields may be added to a version of the schema; it is increased when one is removed or changes meaning.
+const OutputSchemaVersion = 1
+
+// kinds of the documents printed with --json
+const (
+	kindVersions      = "versions"
+	kindVersion       = "version"
+	kindReleaseStatus = "release-status"
+	kindStatus        = "status"
+	kindInfo          = "info"
+)
+
+// DocumentHeader starts every document printed with --json
+type DocumentHeader struct {
+	// Schema is OutputSchemaVersion
+	Schema int `json:"schema"`
+	// Kind is versions (gop ls, gop ls installed), version (gop ls latest, gop ls stable, gop bin),
+	// release-status (gop ls --status), status (gop status) or info (gop info)
+	Kind string `json:"kind"`
+}
+
+func newDocumentHeader(kind string) DocumentHeader {
+	return DocumentHeader{Schema: OutputSchemaVersion, Kind: kind}
+}
+
+// VersionOutput describes a version in the documents printed with --json
+type VersionOutput struct {
+	Version string `json:"version"`
+	// Installed reports whether the version is installed. The other fields are only set if it is.
+	Installed bool `json:"installed"`
+	// Active reports whether the version is the current one: set for the shell, or activated
+	Active bool `json:"active"`
+	// Path is the python executable
+	Path string `json:"path,omitempty"`
+	// Method is "source" or "prebuilt", and DownloadURL the URL the version was downloaded from.
+	// They are empty, as is InstalledAt, for versions installed before builds were recorded.
+	Method      string     `json:"method,omitempty"`
+	DownloadURL string     `json:"download_url,omitempty"`
+	InstalledAt *time.Time `json:"installed_at,omitempty"`
+}
+
+// VersionsDocument is a list of versions, oldest first
+type VersionsDocument struct {
+	DocumentHeader
+	Versions []VersionOutput `json:"versions"`
+}
+
+// VersionDocument is a single version
+type VersionDocument struct {
+	DocumentHeader
+	VersionOutput
+}
+
+// ReleaseStatusOutput is the support phase of a minor line, see ReleaseStatus
+type ReleaseStatusOutput struct {
+	Line   string `json:"line"`
+	Status string `json:"status"`
+	// Latest is the latest available release of the line, empty if there is none
+	Latest       string `json:"latest,omitempty"`
+	FirstRelease string `json:"first_release"`
+	EndOfLife    string `json:"end_of_life"`
+	// Active reports whether the current version is in the line
+	Active bool `json:"active"`
+}
+
+// ReleaseStatusDocument is the support phase of every known minor line, oldest first
+type ReleaseStatusDocument struct {
+	DocumentHeader
+	Lines []ReleaseStatusOutput `json:"lines"`
+}
+
+// StatusDocument is the status of the version selected for the working directory
+type StatusDocument struct {
+	DocumentHeader
+	*Status
+}
+
+// DirsOutput are the directories of an installed version
+type DirsOutput struct {
+	Bin     string `json:"bin"`
+	Lib     string `json:"lib"`
+	Include string `json:"include"`
+	Share   string `json:"share"`
+}
+
+// BuildOutput are the options a version was built from source with
+type BuildOutput struct {
+	ConfigureOpts []string `json:"configure_opts"`
+	MakeOpts      []string `json:"make_opts"`
+	CFLAGS        string   `json:"cflags"`
+	LDFLAGS       string   `json:"ldflags"`
+	// LogFile is the build log
+	LogFile string `json:"log_file,omitempty"`
+	// MissingModules are the optional standard library modules that cannot be imported
+	MissingModules []string `json:"missing_modules"`
+}
+
+// InfoDocument is an installed version, its directories and how it was built
+type InfoDocument struct {
+	DocumentHeader
+	VersionOutput
+	Dirs DirsOutput `json:"dirs"`
+	// Build is null for prebuilt distributions and versions installed before builds were recorded
+	Build *BuildOutput `json:"build"`
+}
+
+// emptyIfNil returns an empty slice for nil, so that it is a list in JSON documents
+func emptyIfNil(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
+
+// describeVersion returns the description of a version for the documents, given whether it is installed
+// and the current version
+func (m *Manager) describeVersion(versionStr string, installed bool, current string) (VersionOutput, error) {
+	out := VersionOutput{Version: versionStr, Active: versionStr == current}
+	if !installed {
+		return out, nil
+	}
+	out.Installed = true
+	out.Path = m.getVersionDirectories(versionStr).Executable
+
+	info, err := m.GetBuildInfo(versionStr)
+	if os.IsNotExist(err) {
+		return out, nil
+	} else if err != nil {
+		return out, err
+	}
+	out.Method = info.Method
+	out.DownloadURL = info.Source
+	if !info.InstalledAt.IsZero() {
+		out.InstalledAt = &info.InstalledAt
+	}
+	return out, nil
+}
+
+// describeInfo returns the info document of an installed version
+func (m *Manager) describeInfo(versionStr string, current string) (*InfoDocument, error) {
+	files, err := m.VersionFiles(versionStr)
+	if err != nil {
+		return nil, err
+	}
+	version, err := m.describeVersion(versionStr, true, current)
+	if err != nil {
+		return nil, err
+	}
+	doc := &InfoDocument{
+		DocumentHeader: newDocumentHeader(kindInfo),
+		VersionOutput:  version,
+		Dirs:           DirsOutput{Bin: files.BinDir, Lib: files.LibDir, Include: files.IncludeDir, Share: files.ShareDir},
+	}
+
+	info, err := m.GetBuildInfo(versionStr)
+	if os.IsNotExist(err) {
+		return doc, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if info.Method == installFromSource {
+		doc.Build = &BuildOutput{
+			ConfigureOpts:  emptyIfNil(info.ConfigureOpts),
+			MakeOpts:       emptyIfNil(info.MakeOpts),
+			CFLAGS:         info.CFLAGS,
+			LDFLAGS:        info.LDFLAGS,
+			LogFile:        info.LogFile,
+			MissingModules: emptyIfNil(info.MissingModules),
+		}
+	}
+	return doc, nil
+}
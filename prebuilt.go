@@ -0,0 +1,110 @@
+package pgo
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+const (
+	// build python from the source tarball on the mirror
+	installFromSource = "source"
+	// unpack a prebuilt relocatable distribution (python-build-standalone style)
+	installFromPrebuilt = "prebuilt"
+)
+
+// ErrNoPrebuiltURL is returned when installing a prebuilt distribution without P_PREBUILT_URL
+var ErrNoPrebuiltURL = fmt.Errorf("no prebuilt URL template configured (set P_PREBUILT_URL)")
+
+// prebuiltArchs maps GOARCH to the architecture names used by prebuilt distributions
+var prebuiltArchs = map[string]string{
+	"amd64": "x86_64",
+	"386":   "i686",
+	"arm64": "aarch64",
+	"arm":   "armv7",
+}
+
+// prebuiltPlatforms maps GOOS to the vendor-os part of the target triple used by prebuilt distributions
+var prebuiltPlatforms = map[string]string{
+	"linux":   "unknown-linux-gnu",
+	"darwin":  "apple-darwin",
+	"windows": "pc-windows-msvc",
+}
+
+// getPrebuiltURL expands the prebuilt URL template for the given version and the current platform.
+// The template may contain {version}, {major}, {minor}, {arch}, {platform} and {triple} (arch-platform).
+func getPrebuiltURL(urlTemplate string, versionStr string) (string, error) {
+	if urlTemplate == "" {
+		return "", ErrNoPrebuiltURL
+	}
+	pver, err := parsePyVersion(versionStr)
+	if err != nil {
+		return "", err
+	}
+	arch, ok := prebuiltArchs[runtime.GOARCH]
+	if !ok {
+		arch = runtime.GOARCH
+	}
+	platform, ok := prebuiltPlatforms[runtime.GOOS]
+	if !ok {
+		platform = runtime.GOOS
+	}
+	replacer := strings.NewReplacer(
+		"{version}", versionStr,
+		"{major}", fmt.Sprint(pver.Major),
+		"{minor}", fmt.Sprint(pver.Minor),
+		"{arch}", arch,
+		"{platform}", platform,
+		"{triple}", arch+"-"+platform,
+	)
+	return replacer.Replace(urlTemplate), nil
+}
+
+// unarchivePrebuilt extracts a .tar.gz or .tar.zst archive. Zstandard archives are handed to the system tar.
+func (m *Manager) unarchivePrebuilt(installerFile string, targetDir string) error {
+	if !strings.HasSuffix(installerFile, ".tar.zst") {
+		return m.extractTarGz(installerFile, targetDir)
+	}
+	if err := m.fs.MkdirAll(targetDir, 0755); err != nil {
+		return err
+	}
+	out, err := m.output(Command{Name: "tar", Args: []string{"--zstd", "-xf", installerFile, "-C", targetDir}}, true)
+	if err != nil {
+		logger.Debugf("`tar --zstd` output: %s", out)
+		return fmt.Errorf("unable to extract %s: %w", installerFile, err)
+	}
+	return nil
+}
+
+// findPrebuiltRoot returns the directory of an extracted distribution that holds bin/, lib/, etc.
+// install_only archives use python/, full archives use python/install/.
+func (m *Manager) findPrebuiltRoot(extractedDir string) (string, error) {
+	for _, candidate := range []string{
+		filepath.Join(extractedDir, "python", "install"),
+		filepath.Join(extractedDir, "python"),
+		extractedDir,
+	} {
+		if _, err := m.fs.Stat(filepath.Join(candidate, "bin")); err == nil {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("no python installation found in %s", extractedDir)
+}
+
+// installPrebuilt unpacks a prebuilt distribution in stagingDir, and returns the directory
+// with the same layout as a source build (bin/, lib/, ...)
+func (m *Manager) installPrebuilt(installerFile string, versionDir string, stagingDir string, info BuildInfo) (string, error) {
+	extractedDir := filepath.Join(stagingDir, "extracted")
+	m.emit(Event{Type: EventExtracting, Message: installerFile})
+	if err := m.unarchivePrebuilt(installerFile, extractedDir); err != nil {
+		return "", err
+	}
+	if err := m.context().Err(); err != nil {
+		return "", err
+	}
+	logger.Debugf("extracted to %s", extractedDir)
+
+	m.emit(Event{Type: EventInstalling, Message: extractedDir})
+	return m.findPrebuiltRoot(extractedDir)
+}
@@ -0,0 +1,123 @@
+package pgo
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// buildDependency is a header needed to build an optional module of the standard library
+type buildDependency struct {
+	module string
+	header string
+	// package providing the header on Debian/Ubuntu
+	debPackage string
+	// package providing the header on Fedora/RHEL
+	rpmPackage string
+	// pkg-config package giving the include directories of headers that are not in the default ones
+	pkgConfig string
+}
+
+var buildDependencies = []buildDependency{
+	{module: "ssl", header: "openssl/ssl.h", debPackage: "libssl-dev", rpmPackage: "openssl-devel"},
+	{module: "zlib", header: "zlib.h", debPackage: "zlib1g-dev", rpmPackage: "zlib-devel"},
+	{module: "ctypes", header: "ffi.h", debPackage: "libffi-dev", rpmPackage: "libffi-devel"},
+	{module: "sqlite3", header: "sqlite3.h", debPackage: "libsqlite3-dev", rpmPackage: "sqlite-devel"},
+	{module: "bz2", header: "bzlib.h", debPackage: "libbz2-dev", rpmPackage: "bzip2-devel"},
+	{module: "lzma", header: "lzma.h", debPackage: "liblzma-dev", rpmPackage: "xz-devel"},
+	{module: "readline", header: "readline/readline.h", debPackage: "libreadline-dev", rpmPackage: "readline-devel"},
+	// tk.h is in /usr/include/tcl8.6 on Debian/Ubuntu, where configure also finds it with pkg-config
+	{module: "tkinter", header: "tk.h", debPackage: "tk-dev", rpmPackage: "tk-devel", pkgConfig: "tk"},
+}
+
+// optional modules checked after installation, by python major version
+var optionalModules = map[uint64][]string{
+	2: {"ssl", "sqlite3", "ctypes", "zlib", "bz2", "readline", "Tkinter"},
+	3: {"ssl", "sqlite3", "ctypes", "zlib", "bz2", "lzma", "readline", "tkinter"},
+}
+
+// importCheckScript prints the modules given as arguments that cannot be imported
+const importCheckScript = `import sys
+missing = []
+for name in sys.argv[1:]:
+    try:
+        __import__(name)
+    except Exception:
+        missing.append(name)
+print(" ".join(missing))`
+
+// getCompiler returns the C compiler used for the build: $CC, or the first of cc, gcc and clang on PATH
+func getCompiler() (string, error) {
+	if cc := os.Getenv("CC"); cc != "" {
+		return cc, nil
+	}
+	for _, cc := range []string{"cc", "gcc", "clang"} {
+		if path, err := exec.LookPath(cc); err == nil {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("no C compiler found (install gcc or clang, or set CC)")
+}
+
+// hasHeader reports whether the compiler can find the header, using the build's CFLAGS
+func (m *Manager) hasHeader(cc string, cflags string, header string) bool {
+	args := append(strings.Fields(cflags), "-E", "-x", "c", "-")
+	cmd := Command{Name: cc, Args: args, Stdin: strings.NewReader(fmt.Sprintf("#include <%s>\n", header))}
+	return m.runner.Run(m.context(), cmd) == nil
+}
+
+// preflightBuild checks that python can be built from source: a C compiler and make are required,
+// and missing headers are reported with the modules that will be left out because of them.
+// With strict, missing headers fail the build before it starts, as the modules would fail it at the end.
+func (m *Manager) preflightBuild(info BuildInfo, strict bool) error {
+	cc, err := getCompiler()
+	if err != nil {
+		return err
+	}
+	if _, err := exec.LookPath("make"); err != nil {
+		return fmt.Errorf("make not found, it is needed to build python from source")
+	}
+
+	if missing := m.missingHeaders(cc, info.CFLAGS); len(missing) > 0 && strict {
+		return fmt.Errorf("headers not found, python would be built without: %s", strings.Join(missing, "; "))
+	} else if len(missing) > 0 {
+		logger.Warningf("headers not found, python will be built without: %s", strings.Join(missing, "; "))
+	}
+	return nil
+}
+
+// missingHeaders returns the build dependencies whose header the compiler cannot find, with the packages providing them
+func (m *Manager) missingHeaders(cc string, buildCFLAGS string) []string {
+	missing := []string{}
+	for _, dep := range buildDependencies {
+		cflags := buildCFLAGS
+		if dep.pkgConfig != "" {
+			if out, err := m.output(Command{Name: "pkg-config", Args: []string{"--cflags", dep.pkgConfig}}, false); err == nil {
+				cflags = strings.TrimSpace(cflags + " " + strings.TrimSpace(string(out)))
+			}
+		}
+		if !m.hasHeader(cc, cflags, dep.header) {
+			missing = append(missing, fmt.Sprintf("%s (%s, from %s or %s)", dep.module, dep.header, dep.debPackage, dep.rpmPackage))
+		}
+	}
+	return missing
+}
+
+// checkOptionalModules returns the optional standard library modules the installed python cannot import
+func (m *Manager) checkOptionalModules(pythonPath string, versionStr string) ([]string, error) {
+	pver, err := parsePyVersion(versionStr)
+	if err != nil {
+		return nil, err
+	}
+	modules, ok := optionalModules[pver.Major]
+	if !ok {
+		return []string{}, nil
+	}
+	args := append([]string{"-c", importCheckScript}, modules...)
+	out, err := m.output(Command{Name: pythonPath, Args: args, Env: stagedPythonEnv(pythonPath)}, false)
+	if err != nil {
+		return nil, fmt.Errorf("unable to check modules of %s: %w", pythonPath, err)
+	}
+	return strings.Fields(string(out)), nil
+}
@@ -0,0 +1,94 @@
+package pgo
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// headerRunner is a compiler finding the headers of its include directories, and a pkg-config
+// giving the include directories of its packages
+type headerRunner struct {
+	headers   map[string]string
+	pkgConfig map[string]string
+}
+
+func (r *headerRunner) Run(ctx context.Context, cmd Command) error {
+	switch cmd.Name {
+	case "pkg-config":
+		cflags, ok := r.pkgConfig[cmd.Args[len(cmd.Args)-1]]
+		if !ok {
+			return fmt.Errorf("package not found")
+		}
+		fmt.Fprintln(cmd.Stdout, cflags)
+		return nil
+	case "cc":
+		source, err := ioutil.ReadAll(cmd.Stdin)
+		if err != nil {
+			return err
+		}
+		header := strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(string(source)), "#include <"), ">")
+		dir, ok := r.headers[header]
+		if !ok {
+			return fmt.Errorf("%s: no such file", header)
+		}
+		if dir != "" && !strings.Contains(" "+strings.Join(cmd.Args, " ")+" ", " -I"+dir+" ") {
+			return fmt.Errorf("%s: no such file", header)
+		}
+		return nil
+	}
+	return fmt.Errorf("unexpected command %s", cmd.Name)
+}
+
+func TestMissingHeadersFindsTkWithPkgConfig(t *testing.T) {
+	runner := &headerRunner{headers: map[string]string{}, pkgConfig: map[string]string{"tk": "-I/usr/include/tcl8.6"}}
+	for _, dep := range buildDependencies {
+		runner.headers[dep.header] = ""
+	}
+	runner.headers["tk.h"] = "/usr/include/tcl8.6"
+	m := NewManager(Config{PPrefix: t.TempDir()}, ManagerOptions{Runner: runner})
+
+	if missing := m.missingHeaders("cc", "-O2"); len(missing) > 0 {
+		t.Errorf("missingHeaders = %v, want none", missing)
+	}
+	delete(runner.pkgConfig, "tk")
+	if missing := m.missingHeaders("cc", "-O2"); len(missing) != 1 || !strings.HasPrefix(missing[0], "tkinter ") {
+		t.Errorf("missingHeaders without pkg-config = %v, want tkinter", missing)
+	}
+}
+
+func TestStrictInstallFailsBeforeConfigure(t *testing.T) {
+	m, fs, runner := newMemManager(t, "3.12.1")
+	writeMirrorRelease(t, fs, "/mirror", "3.12.1")
+	m.runner = &missingHeaderBuild{fakeBuild: runner, header: "ffi.h"}
+
+	if err := m.InstallPythonVersionWithOptions("3.12.1", InstallOptions{Strict: true}); err == nil || !strings.Contains(err.Error(), "ffi.h") {
+		t.Fatalf("strict install without ffi.h = %v, want the missing header", err)
+	}
+	for _, command := range runner.commands {
+		if strings.HasPrefix(command, "./configure") {
+			t.Errorf("configure ran: %s", command)
+		}
+	}
+}
+
+// missingHeaderBuild is a build whose compiler does not find the header
+type missingHeaderBuild struct {
+	*fakeBuild
+	header string
+}
+
+func (b *missingHeaderBuild) Run(ctx context.Context, cmd Command) error {
+	if cmd.Name == "cc" && cmd.Stdin != nil {
+		source, err := ioutil.ReadAll(cmd.Stdin)
+		if err != nil {
+			return err
+		}
+		if strings.Contains(string(source), "<"+b.header+">") {
+			return fmt.Errorf("%s: no such file", b.header)
+		}
+	}
+	return b.fakeBuild.Run(ctx, cmd)
+}
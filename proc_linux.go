@@ -0,0 +1,8 @@
+package pgo
+
+import "syscall"
+
+// setParentDeathSignal has the command killed if gop dies without killing it, e.g. with SIGKILL
+func setParentDeathSignal(attr *syscall.SysProcAttr) {
+	attr.Pdeathsig = syscall.SIGKILL
+}
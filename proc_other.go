@@ -0,0 +1,10 @@
+//go:build !linux && !windows
+// +build !linux,!windows
+
+package pgo
+
+import "syscall"
+
+// setParentDeathSignal does nothing where there is no parent death signal:
+// a build keeps running if gop dies without killing it
+func setParentDeathSignal(attr *syscall.SysProcAttr) {}
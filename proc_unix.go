@@ -0,0 +1,22 @@
+//go:build !windows
+// +build !windows
+
+package pgo
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup makes the command the leader of a new process group. Signals from the terminal
+// do not reach the group, so gop kills it when interrupted, see startContext.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	setParentDeathSignal(cmd.SysProcAttr)
+}
+
+// killProcessGroup kills the process group led by the process
+func killProcessGroup(process *os.Process) error {
+	return syscall.Kill(-process.Pid, syscall.SIGKILL)
+}
@@ -0,0 +1,16 @@
+//go:build windows
+// +build windows
+
+package pgo
+
+import (
+	"os"
+	"os/exec"
+)
+
+// setProcessGroup does nothing on windows, where only the process itself is killed
+func setProcessGroup(cmd *exec.Cmd) {}
+
+func killProcessGroup(process *os.Process) error {
+	return process.Kill()
+}
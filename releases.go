@@ -0,0 +1,231 @@
+package pgo
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	// path after prefix of the cached release status table
+	releaseStatusPath = "p/release-status.json"
+	// release status table of the Python developer's guide, one entry per minor line
+	defaultReleaseStatusURL = "https://raw.githubusercontent.com/python/devguide/main/include/release-cycle.json"
+)
+
+// support phases of a minor line, as named in the Python developer's guide
+const (
+	phaseFeature    = "feature"
+	phasePrerelease = "prerelease"
+	phaseBugfix     = "bugfix"
+	phaseSecurity   = "security"
+	phaseEndOfLife  = "end-of-life"
+)
+
+// ReleaseStatus is the support phase of a minor line
+type ReleaseStatus struct {
+	// Line is the "X.Y" minor line
+	Line string `json:"-"`
+	// Status is feature, prerelease, bugfix, security or end-of-life
+	Status string `json:"status"`
+	// FirstRelease is the date of the X.Y.0 release, YYYY-MM-DD
+	FirstRelease string `json:"first_release"`
+	// EndOfLife is the (planned) date the line stops receiving fixes, YYYY-MM-DD or YYYY-MM
+	EndOfLife string `json:"end_of_life"`
+}
+
+// bundledReleaseStatus is used until the table has been downloaded, and when it cannot be
+var bundledReleaseStatus = map[string]ReleaseStatus{
+	"2.7":  {Status: phaseEndOfLife, FirstRelease: "2010-07-03", EndOfLife: "2020-01-01"},
+	"3.5":  {Status: phaseEndOfLife, FirstRelease: "2015-09-13", EndOfLife: "2020-09-30"},
+	"3.6":  {Status: phaseEndOfLife, FirstRelease: "2016-12-23", EndOfLife: "2021-12-23"},
+	"3.7":  {Status: phaseEndOfLife, FirstRelease: "2018-06-27", EndOfLife: "2023-06-27"},
+	"3.8":  {Status: phaseEndOfLife, FirstRelease: "2019-10-14", EndOfLife: "2024-10-07"},
+	"3.9":  {Status: phaseEndOfLife, FirstRelease: "2020-10-05", EndOfLife: "2025-10-31"},
+	"3.10": {Status: phaseSecurity, FirstRelease: "2021-10-04", EndOfLife: "2026-10"},
+	"3.11": {Status: phaseSecurity, FirstRelease: "2022-10-24", EndOfLife: "2027-10"},
+	"3.12": {Status: phaseSecurity, FirstRelease: "2023-10-02", EndOfLife: "2028-10"},
+	"3.13": {Status: phaseBugfix, FirstRelease: "2024-10-07", EndOfLife: "2029-10"},
+	"3.14": {Status: phaseBugfix, FirstRelease: "2025-10-07", EndOfLife: "2030-10"},
+	"3.15": {Status: phasePrerelease, FirstRelease: "2026-10-01", EndOfLife: "2031-10"},
+}
+
+// parseEndOfLife returns the first day a line is out of support. A month means the end of that month.
+func parseEndOfLife(date string) (time.Time, bool) {
+	if t, err := time.Parse("2006-01-02", date); err == nil {
+		return t, true
+	}
+	if t, err := time.Parse("2006-01", date); err == nil {
+		return t.AddDate(0, 1, 0), true
+	}
+	return time.Time{}, false
+}
+
+// effective returns the status, marking lines past their end of life date as such even if the table is older
+func (rs ReleaseStatus) effective(now time.Time) ReleaseStatus {
+	if eol, ok := parseEndOfLife(rs.EndOfLife); ok && !now.Before(eol) {
+		rs.Status = phaseEndOfLife
+	}
+	return rs
+}
+
+// IsEndOfLife reports whether the line no longer receives security fixes
+func (rs ReleaseStatus) IsEndOfLife() bool {
+	return rs.Status == phaseEndOfLife
+}
+
+// readReleaseStatusCache returns the cached release status table, and when it was downloaded
+func (m *Manager) readReleaseStatusCache() (map[string]ReleaseStatus, time.Time, error) {
+	statusFile := filepath.Join(m.cfg.PPrefix, releaseStatusPath)
+	info, err := m.fs.Stat(statusFile)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	data, err := m.fs.ReadFile(statusFile)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	statuses := map[string]ReleaseStatus{}
+	if err := json.Unmarshal(data, &statuses); err != nil {
+		return nil, time.Time{}, err
+	}
+	return statuses, info.ModTime(), nil
+}
+
+// refreshReleaseStatus downloads the release status table and caches it
+func (m *Manager) refreshReleaseStatus() (map[string]ReleaseStatus, error) {
+	if m.cfg.POffline {
+		return nil, fmt.Errorf("%w: the release status table would have to be downloaded", ErrOffline)
+	}
+	data, err := m.fetchURL(m.cfg.PReleaseStatusURL)
+	if err != nil {
+		return nil, err
+	}
+	statuses := map[string]ReleaseStatus{}
+	if err := json.Unmarshal(data, &statuses); err != nil {
+		return nil, fmt.Errorf("invalid release status table %s: %w", m.cfg.PReleaseStatusURL, err)
+	}
+
+	statusFile := filepath.Join(m.cfg.PPrefix, releaseStatusPath)
+	if err := m.fs.MkdirAll(filepath.Dir(statusFile), 0755); err != nil {
+		return nil, err
+	}
+	tmpFile := fmt.Sprintf("%s.%d", statusFile, os.Getpid())
+	if err := m.fs.WriteFile(tmpFile, data, 0644); err != nil {
+		return nil, err
+	}
+	return statuses, m.fs.Rename(tmpFile, statusFile)
+}
+
+// updateReleaseStatusCache downloads the release status table under the cache lock, unless another
+// process did while waiting for it, and returns it. It returns cached if the table cannot be downloaded.
+func (m *Manager) updateReleaseStatusCache(cached map[string]ReleaseStatus) map[string]ReleaseStatus {
+	release, err := m.acquireLocks(cacheLock)
+	if err != nil {
+		logger.Debugf("unable to lock the release status cache: %s", err)
+		return cached
+	}
+	defer release()
+
+	if statuses, fetchedAt, err := m.readReleaseStatusCache(); err == nil && time.Since(fetchedAt) < m.cfg.PIndexTTL {
+		return statuses
+	}
+	fresh, err := m.refreshReleaseStatus()
+	if err != nil {
+		logger.Debugf("unable to download the release status table: %s", err)
+		return cached
+	}
+	return fresh
+}
+
+// getReleaseStatusTable returns the release status of each minor line: the bundled table, updated with
+// the downloaded one. The download is cached for as long as the version index.
+func (m *Manager) getReleaseStatusTable() map[string]ReleaseStatus {
+	return m.releaseStatusTable(true)
+}
+
+// releaseStatusTable returns the release status of each minor line, downloading the table again
+// if refresh is set and the cached one is too old. Without refresh, the cached table is used
+// whatever its age, or the bundled one if nothing is cached.
+func (m *Manager) releaseStatusTable(refresh bool) map[string]ReleaseStatus {
+	table := map[string]ReleaseStatus{}
+	for line, rs := range bundledReleaseStatus {
+		table[line] = rs
+	}
+
+	downloaded, fetchedAt, err := m.readReleaseStatusCache()
+	if refresh && (err != nil || (time.Since(fetchedAt) >= m.cfg.PIndexTTL && !m.cfg.POffline)) {
+		downloaded = m.updateReleaseStatusCache(downloaded)
+	}
+	for line, rs := range downloaded {
+		table[line] = rs
+	}
+
+	now := time.Now()
+	for line, rs := range table {
+		rs.Line = line
+		table[line] = rs.effective(now)
+	}
+	return table
+}
+
+// GetReleaseStatus looks up the support phase of a version's minor line in the environment's release status table
+func GetReleaseStatus(versionStr string) (ReleaseStatus, bool) {
+	return defaultManager().GetReleaseStatus(versionStr)
+}
+
+// GetReleaseStatus returns the support phase of the minor line of a version
+func (m *Manager) GetReleaseStatus(versionStr string) (ReleaseStatus, bool) {
+	pver, err := parsePyVersion(versionStr)
+	if err != nil {
+		return ReleaseStatus{}, false
+	}
+	rs, ok := m.getReleaseStatusTable()[minorLine(pver)]
+	return rs, ok
+}
+
+// GetReleaseStatuses returns the support phases of all minor lines, downloading the table if it is not cached
+func GetReleaseStatuses() []ReleaseStatus {
+	return defaultManager().GetReleaseStatuses()
+}
+
+// GetReleaseStatuses returns the support phase of every known minor line, oldest first
+func (m *Manager) GetReleaseStatuses() []ReleaseStatus {
+	table := m.getReleaseStatusTable()
+	statuses := make([]ReleaseStatus, 0, len(table))
+	for _, rs := range table {
+		statuses = append(statuses, rs)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		a, _, _ := parsePartialVersion(statuses[i].Line)
+		b, _, _ := parsePartialVersion(statuses[j].Line)
+		return a.Compare(b) < 0
+	})
+	return statuses
+}
+
+// RefreshReleaseStatus downloads the release status table from P_RELEASE_STATUS_URL again
+func RefreshReleaseStatus() error {
+	return defaultManager().RefreshReleaseStatus()
+}
+
+// RefreshReleaseStatus downloads the release status table, replacing the cached one
+func (m *Manager) RefreshReleaseStatus() error {
+	_, err := m.refreshReleaseStatus()
+	return err
+}
+
+// warnIfEndOfLife logs a warning if the version's minor line no longer receives security fixes.
+// It is called while the active links are locked, so it only reads the cached or bundled table.
+func (m *Manager) warnIfEndOfLife(versionStr string) {
+	pver, err := parsePyVersion(versionStr)
+	if err != nil {
+		return
+	}
+	if rs, ok := m.releaseStatusTable(false)[minorLine(pver)]; ok && rs.IsEndOfLife() {
+		logger.Warningf("Python %s reached its end of life on %s and no longer receives security fixes", rs.Line, rs.EndOfLife)
+	}
+}
@@ -0,0 +1,35 @@
+package pgo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestActivateDoesNotDownloadReleaseStatus(t *testing.T) {
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Write([]byte(`{"3.8": {"status": "end-of-life", "first_release": "2019-10-14", "end_of_life": "2024-10-07"}}`))
+	}))
+	defer ts.Close()
+
+	prefix := t.TempDir()
+	installFakeVersion(t, prefix, "3.8.10")
+	m := NewManager(Config{PPrefix: prefix, PReleaseStatusURL: ts.URL}, ManagerOptions{HTTPClient: ts.Client()})
+	if err := m.ActivatePythonVersion("3.8.10"); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&requests); n != 0 {
+		t.Errorf("activating downloaded the release status table %d time(s)", n)
+	}
+
+	// the table is still downloaded when asked for
+	if _, ok := m.GetReleaseStatus("3.8.10"); !ok {
+		t.Error("no release status for 3.8")
+	}
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Errorf("GetReleaseStatus downloaded the release status table %d time(s), want 1", n)
+	}
+}
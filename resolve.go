@@ -0,0 +1,270 @@
+package pgo
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// versionConstraint is a single comparison against a (possibly partial) version, e.g. ">=3.9"
+type versionConstraint struct {
+	op      string
+	version pyVersion
+	// number of components given in the specification (1 for "3", 2 for "3.11", 3 for "3.11.2")
+	parts int
+}
+
+// versionSpec is a set of constraints that must all be satisfied
+type versionSpec []versionConstraint
+
+var specOperators = []string{">=", "<=", "==", "!=", "~=", "~", ">", "<", "="}
+
+// ErrInvalidVersion is wrapped by the errors for versions and specifications that cannot be parsed
+var ErrInvalidVersion = fmt.Errorf("invalid version specification")
+
+// VersionNotFoundError is a specification that none of the candidate versions satisfies
+type VersionNotFoundError struct {
+	Spec string
+	// Candidates are the versions that were considered, installed or available on the mirror
+	Candidates []string
+	// Installed reports whether only installed versions were considered
+	Installed bool
+}
+
+func (e *VersionNotFoundError) Error() string {
+	if e.Installed {
+		return fmt.Sprintf("no installed version matching %s", e.Spec)
+	}
+	return fmt.Sprintf("no version matching %s found", e.Spec)
+}
+
+func parseVersionSpec(spec string) (versionSpec, error) {
+	for _, prefix := range ignorePrefixes {
+		spec = strings.TrimPrefix(spec, prefix)
+	}
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, fmt.Errorf("%w: empty", ErrInvalidVersion)
+	}
+
+	constraints := versionSpec{}
+	for _, term := range strings.Split(spec, ",") {
+		term = strings.TrimSpace(term)
+		op := ""
+		for _, specOp := range specOperators {
+			if strings.HasPrefix(term, specOp) {
+				op = specOp
+				break
+			}
+		}
+		term = strings.TrimSpace(strings.TrimPrefix(term, op))
+		// an inclusive range of partial versions, e.g. 3.9-3.12
+		if bounds := strings.SplitN(term, "-", 2); op == "" && len(bounds) == 2 {
+			if _, err := parsePyVersion(term); err != nil {
+				low, lowParts, lowErr := parsePartialVersion(strings.TrimSpace(bounds[0]))
+				high, highParts, highErr := parsePartialVersion(strings.TrimSpace(bounds[1]))
+				if lowErr != nil || highErr != nil {
+					return nil, fmt.Errorf("%w %q: invalid range %q", ErrInvalidVersion, spec, term)
+				}
+				constraints = append(constraints,
+					versionConstraint{op: ">=", version: low, parts: lowParts},
+					versionConstraint{op: "<=", version: high, parts: highParts})
+				continue
+			}
+		}
+		version, parts, err := parsePartialVersion(term)
+		if err != nil {
+			return nil, fmt.Errorf("%w %q: %s", ErrInvalidVersion, spec, err)
+		}
+		if op == "~=" && parts < 2 {
+			return nil, fmt.Errorf("%w %q: ~= requires at least X.Y", ErrInvalidVersion, spec)
+		}
+		constraints = append(constraints, versionConstraint{op: op, version: version, parts: parts})
+	}
+	return constraints, nil
+}
+
+// parsePartialVersion parses "3", "3.11", "3.11.x", "3.11.2" or "3.13.0rc2", padding missing components with zeros
+func parsePartialVersion(vstr string) (pyVersion, int, error) {
+	if pver, err := parsePyVersion(vstr); err == nil {
+		return pver, 3, nil
+	}
+	components := strings.Split(vstr, ".")
+	if len(components) > 3 {
+		return pyVersion{}, 0, fmt.Errorf("too many version components")
+	}
+	numbers := []uint64{0, 0, 0}
+	parts := 0
+	for idx, component := range components {
+		if component == "x" || component == "X" || component == "*" {
+			if idx != len(components)-1 {
+				return pyVersion{}, 0, fmt.Errorf("wildcard must be the last component")
+			}
+			break
+		}
+		n, err := strconv.ParseUint(component, 10, 64)
+		if err != nil {
+			return pyVersion{}, 0, fmt.Errorf("%q is not a number", component)
+		}
+		numbers[idx] = n
+		parts++
+	}
+	if parts == 0 {
+		return pyVersion{}, 0, fmt.Errorf("no version given")
+	}
+	return pyVersion{Major: numbers[0], Minor: numbers[1], Patch: numbers[2]}, parts, nil
+}
+
+// prefixMatches reports whether the first `parts` components of a and b are equal
+func prefixMatches(a, b pyVersion, parts int) bool {
+	switch parts {
+	case 1:
+		return a.Major == b.Major
+	case 2:
+		return a.Major == b.Major && a.Minor == b.Minor
+	default:
+		return a.Major == b.Major && a.Minor == b.Minor && a.Patch == b.Patch
+	}
+}
+
+func (vc versionConstraint) matches(v pyVersion) bool {
+	cmp := v.Compare(vc.version)
+	switch vc.op {
+	case "", "=", "==":
+		if vc.version.IsPrerelease() {
+			return cmp == 0
+		}
+		return prefixMatches(v, vc.version, vc.parts)
+	case "!=":
+		if vc.version.IsPrerelease() {
+			return cmp != 0
+		}
+		return !prefixMatches(v, vc.version, vc.parts)
+	case ">=":
+		return cmp >= 0
+	case "<=":
+		return cmp <= 0 || prefixMatches(v, vc.version, vc.parts)
+	case ">":
+		return cmp > 0 && !prefixMatches(v, vc.version, vc.parts)
+	case "<":
+		return cmp < 0
+	case "~":
+		// ~3.11 and ~3.11.2 allow patch updates, ~3 allows minor updates
+		prefix := vc.parts
+		if prefix > 2 {
+			prefix = 2
+		}
+		return cmp >= 0 && prefixMatches(v, vc.version, prefix)
+	case "~=":
+		// PEP 440 compatible release: ~=3.11 is >=3.11,==3.*
+		return cmp >= 0 && prefixMatches(v, vc.version, vc.parts-1)
+	}
+	return false
+}
+
+// allowsPrerelease reports whether pre-releases may satisfy the specification,
+// which (as in PEP 440) is only the case when the specification names one
+func (vs versionSpec) allowsPrerelease() bool {
+	for _, vc := range vs {
+		if vc.version.IsPrerelease() {
+			return true
+		}
+	}
+	return false
+}
+
+func (vs versionSpec) matches(v pyVersion) bool {
+	if v.IsPrerelease() && !vs.allowsPrerelease() {
+		return false
+	}
+	for _, vc := range vs {
+		if !vc.matches(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// isExact reports whether the specification names exactly one X.Y.Z (or pre-release) version
+func (vs versionSpec) isExact() bool {
+	return len(vs) == 1 && vs[0].parts == 3 && (vs[0].op == "" || vs[0].op == "=" || vs[0].op == "==")
+}
+
+// newestMatching returns the newest version in versionStrs satisfying the specification
+func (vs versionSpec) newestMatching(versionStrs []string) (string, bool) {
+	found := false
+	var newest pyVersion
+	for _, vstr := range versionStrs {
+		pver, err := parsePyVersion(vstr)
+		if err != nil || !vs.matches(pver) {
+			continue
+		}
+		if !found || pver.Compare(newest) > 0 {
+			newest = pver
+			found = true
+		}
+	}
+	return newest.String(), found
+}
+
+// ResolveVersion turns a version spec into a version, see Manager.ResolveVersion
+func ResolveVersion(spec string) (string, error) {
+	return defaultManager().ResolveVersion(spec)
+}
+
+// ResolveVersion returns the newest version satisfying the given specification.
+// Specifications may be partial ("3", "3.11", "3.11.x"), constraints ("~3.11", ">=3.9,<3.12")
+// or inclusive ranges ("3.9-3.12").
+// Installed versions are preferred; the mirror is only consulted if none of them match.
+func (m *Manager) ResolveVersion(spec string) (string, error) {
+	return m.ResolveVersionWithOptions(spec, ResolveOptions{})
+}
+
+// ResolveOptions provides a structure for parameters of the resolution of version specifications
+type ResolveOptions struct {
+	// Available resolves against the versions on the mirror only, rather than preferring the installed ones:
+	// "3.12" is the newest 3.12.x release even if an older one is installed, as wanted to install it
+	Available bool
+}
+
+// ResolveVersionWithOptions resolves a version spec like ResolveVersion, with the given options
+func ResolveVersionWithOptions(spec string, opts ResolveOptions) (string, error) {
+	return defaultManager().ResolveVersionWithOptions(spec, opts)
+}
+
+// ResolveVersionWithOptions returns the newest version satisfying the given specification, see ResolveVersion
+func (m *Manager) ResolveVersionWithOptions(spec string, opts ResolveOptions) (string, error) {
+	m.emit(Event{Type: EventResolving, Message: spec})
+	constraints, err := parseVersionSpec(spec)
+	if err != nil {
+		return "", err
+	}
+
+	if !opts.Available {
+		installed, err := m.GetInstalledVersions()
+		if err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+		if vstr, ok := constraints.newestMatching(installed); ok {
+			logger.Debugf("resolved %s to installed version %s", spec, vstr)
+			return vstr, nil
+		}
+	}
+
+	// an exact version does not need the mirror to be resolved
+	if constraints.isExact() {
+		return constraints[0].version.String(), nil
+	}
+
+	available, err := m.GetAvailableVersionsWithOptions(ListOptions{IncludePre: constraints.allowsPrerelease()})
+	if err != nil {
+		return "", err
+	}
+	if vstr, ok := constraints.newestMatching(available); ok {
+		logger.Debugf("resolved %s to available version %s", spec, vstr)
+		return vstr, nil
+	}
+
+	return "", &VersionNotFoundError{Spec: spec, Candidates: available}
+}
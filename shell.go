@@ -0,0 +1,90 @@
+package pgo
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// environment variable set by the `gop init` snippet, naming the shell it was generated for
+const shellEnvVar = "GOP_SHELL"
+
+var supportedShells = []string{"bash", "zsh", "fish"}
+
+// ErrNoShellIntegration is returned when setting the version of the shell without the shell setup of gop init
+var ErrNoShellIntegration = fmt.Errorf("shell integration is not enabled, add `eval \"$(gop init bash)\"` (or zsh/fish) to your shell profile")
+
+// the directories are only added to PATH if missing, so that evaluating the snippet again
+// (e.g. in a nested shell) does not add them twice
+const posixInitTemplate = `export %[1]s=%[2]s
+case ":$PATH:" in
+  *:%[4]s:*) ;;
+  *) export PATH=%[4]s:"$PATH";;
+esac
+case ":$PATH:" in
+  *:%[3]s:*) ;;
+  *) export PATH=%[3]s:"$PATH";;
+esac
+gop() {
+  case "$1" in
+  shell)
+    shift
+    eval "$(%[5]s sh-shell "$@")";;
+  *)
+    %[5]s "$@";;
+  esac
+}
+`
+
+const fishInitTemplate = `set -gx %[1]s %[2]s
+contains -- %[4]s $PATH; or set -gx PATH %[4]s $PATH
+contains -- %[3]s $PATH; or set -gx PATH %[3]s $PATH
+function gop
+  if test "$argv[1]" = "shell"
+    %[5]s sh-shell $argv[2..-1] | source
+  else
+    %[5]s $argv
+  end
+end
+`
+
+// InitScript returns the shell code adding the bin and shims directories of the environment's prefix to PATH
+func InitScript(shell string) (string, error) {
+	return defaultManager().InitScript(shell)
+}
+
+// InitScript returns the snippet to be evaluated by the given shell to set up PATH and the `gop` shell function
+func (m *Manager) InitScript(shell string) (string, error) {
+	gopExec, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	_, activeTarget := m.getActiveDirectories()
+
+	switch shell {
+	case "bash", "zsh":
+		return fmt.Sprintf(posixInitTemplate, shellEnvVar, shell, shellQuote(m.GetShimsDir()), shellQuote(activeTarget.BinDir), shellQuote(gopExec)), nil
+	case "fish":
+		return fmt.Sprintf(fishInitTemplate, shellEnvVar, shell, shellQuote(m.GetShimsDir()), shellQuote(activeTarget.BinDir), shellQuote(gopExec)), nil
+	}
+	return "", fmt.Errorf("unsupported shell %q, must be one of %s", shell, strings.Join(supportedShells, ", "))
+}
+
+// ShellCommands returns the code that sets (or unsets, if versionStr is empty) the
+// session's version override for the shell the `gop` function was set up in
+func ShellCommands(versionStr string) (string, error) {
+	shell := os.Getenv(shellEnvVar)
+	switch shell {
+	case "bash", "zsh":
+		if versionStr == "" {
+			return fmt.Sprintf("unset %s\n", versionEnvVar), nil
+		}
+		return fmt.Sprintf("export %s=%s\n", versionEnvVar, shellQuote(versionStr)), nil
+	case "fish":
+		if versionStr == "" {
+			return fmt.Sprintf("set -e %s\n", versionEnvVar), nil
+		}
+		return fmt.Sprintf("set -gx %s %s\n", versionEnvVar, shellQuote(versionStr)), nil
+	}
+	return "", ErrNoShellIntegration
+}
@@ -0,0 +1,31 @@
+package pgo
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestInitScriptAddsPathOnce(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("no sh")
+	}
+	m := NewManager(Config{PPrefix: t.TempDir()}, ManagerOptions{})
+	script, err := m.InitScript("bash")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := exec.Command(sh, "-c", script+script+`echo "$PATH"`).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := strings.TrimSpace(string(out))
+	if n := strings.Count(path, m.GetShimsDir()); n != 1 {
+		t.Errorf("shims directory %d times on PATH after evaluating the script twice: %s", n, path)
+	}
+	if !strings.HasPrefix(path, m.GetShimsDir()+":") {
+		t.Errorf("shims directory is not first on PATH: %s", path)
+	}
+}
@@ -0,0 +1,287 @@
+package pgo
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"syscall"
+)
+
+const (
+	// path after prefix where shims will be stored
+	shimsPath = "p/shims"
+	// environment variable selecting the version for shims, overriding .python-version
+	versionEnvVar = "GOP_VERSION"
+	// resolution source of the globally activated version
+	globalSource = "global default"
+)
+
+// ErrNoSelectedVersion is returned when running a command while no version is selected
+var ErrNoSelectedVersion = fmt.Errorf("no python version selected (set %s, add a %s file, or activate a version)", versionEnvVar, localVersionFile)
+
+// GetShimsDir returns the shims directory of the prefix set by P_PREFIX
+func GetShimsDir() string {
+	return defaultManager().GetShimsDir()
+}
+
+// GetShimsDir returns the directory shims are generated into
+func (m *Manager) GetShimsDir() string {
+	return filepath.Join(m.cfg.PPrefix, shimsPath)
+}
+
+// resolveInstalled returns the newest installed version matching spec, without consulting the mirror
+func (m *Manager) resolveInstalled(spec string) (string, error) {
+	constraints, err := parseVersionSpec(spec)
+	if err != nil {
+		return "", err
+	}
+	installed, err := m.GetInstalledVersions()
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	vstr, ok := constraints.newestMatching(installed)
+	if !ok {
+		return "", ErrNotInstalled
+	}
+	return vstr, nil
+}
+
+// SelectVersions returns the versions shims run from in the working directory, and where they were selected
+func SelectVersions() ([]string, string, error) {
+	return defaultManager().SelectVersions()
+}
+
+// SelectVersions returns the versions selected for the working directory, in order of preference,
+// and where they were selected from: the GOP_VERSION environment variable, a .python-version file,
+// or the globally activated version. Only installed versions are considered.
+func (m *Manager) SelectVersions() ([]string, string, error) {
+	specs := []string{}
+	source := ""
+	if env := os.Getenv(versionEnvVar); env != "" {
+		specs = []string{env}
+		source = versionEnvVar + " environment variable"
+	} else if _, filename, err := m.GetLocalVersion(); err == nil {
+		if specs, err = m.ReadLocalVersionFile(filename); err != nil {
+			return nil, "", err
+		}
+		source = filename
+	} else if !errors.Is(err, ErrNoLocalVersion) {
+		return nil, "", err
+	} else if active, err := m.getActiveVersion(); err == nil {
+		return []string{active}, globalSource, nil
+	} else {
+		return nil, "", ErrNoSelectedVersion
+	}
+
+	versions := []string{}
+	for _, spec := range specs {
+		if spec == systemVersion {
+			versions = append(versions, systemVersion)
+			continue
+		}
+		vstr, err := m.resolveInstalled(spec)
+		if err != nil {
+			return nil, "", fmt.Errorf("version %s from %s: %w", spec, source, err)
+		}
+		versions = append(versions, vstr)
+	}
+	return versions, source, nil
+}
+
+// findSystemCommand looks for a command on PATH, skipping the shims directory
+func (m *Manager) findSystemCommand(command string) (string, error) {
+	shimsDir := m.GetShimsDir()
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		if dir == shimsDir {
+			continue
+		}
+		candidate := filepath.Join(dir, command)
+		if m.isExecutableFile(candidate) {
+			return candidate, nil
+		}
+	}
+	return "", exec.ErrNotFound
+}
+
+// FindCommand returns the path of command in the versions selected in the working directory, and its version
+func FindCommand(command string) (string, string, error) {
+	return defaultManager().FindCommand(command)
+}
+
+// FindCommand returns the path of the command in the first selected version that provides it
+func (m *Manager) FindCommand(command string) (string, string, error) {
+	versions, source, err := m.SelectVersions()
+	if err != nil {
+		return "", "", err
+	}
+	for _, vstr := range versions {
+		if vstr == systemVersion {
+			if path, err := m.findSystemCommand(command); err == nil {
+				return path, source, nil
+			}
+			continue
+		}
+		dirs := m.getVersionDirectories(vstr)
+		if dirs.BinDir == "" {
+			continue
+		}
+		candidate := filepath.Join(dirs.BinDir, command)
+		if _, err := m.fs.Stat(candidate); err == nil {
+			return candidate, source, nil
+		}
+	}
+	return "", "", fmt.Errorf("%s: command not found in version(s) %v selected by %s", command, versions, source)
+}
+
+// ExecCommand replaces the process with command from the versions selected in the working directory
+func ExecCommand(command string, args []string) error {
+	return defaultManager().ExecCommand(command, args)
+}
+
+// ExecCommand replaces the current process with the command from the selected version.
+// Except on windows, the process is replaced with exec(2) rather than run by the CommandRunner.
+func (m *Manager) ExecCommand(command string, args []string) error {
+	path, source, err := m.FindCommand(command)
+	if err != nil {
+		return err
+	}
+	logger.Infof("running %s (selected by %s)", path, source)
+
+	if runtime.GOOS == "windows" {
+		cmd := Command{Name: path, Args: args, Stdin: os.Stdin, Stdout: os.Stdout, Stderr: os.Stderr}
+		if err := m.runner.Run(m.context(), cmd); err != nil {
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) {
+				os.Exit(exitErr.ExitCode())
+			}
+			return err
+		}
+		return nil
+	}
+	return syscall.Exec(path, append([]string{path}, args...), os.Environ())
+}
+
+// getShimNames returns the names of the executables provided by any installed version
+func (m *Manager) getShimNames() ([]string, error) {
+	installed, err := m.GetInstalledVersions()
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	names := map[string]bool{}
+	for _, vstr := range installed {
+		dirs := m.getVersionDirectories(vstr)
+		if dirs.BinDir == "" {
+			continue
+		}
+		files, err := m.fs.ReadDir(dirs.BinDir)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			// symlinks (python -> python3) are reported with their own mode, so stat the target
+			info, err := m.fs.Stat(filepath.Join(dirs.BinDir, f.Name()))
+			if err != nil || info.IsDir() || info.Mode()&0111 == 0 {
+				continue
+			}
+			names[f.Name()] = true
+		}
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	return sorted, nil
+}
+
+// Rehash rewrites the shims of the prefix set by P_PREFIX
+func Rehash() ([]string, error) {
+	return defaultManager().Rehash()
+}
+
+// Rehash regenerates a shim in the shims directory for every executable of every installed version
+// (python, pip, python3.X, console scripts, ...). Each shim runs `gop shim-exec <name>`, see RunShim.
+// The shims are written to a temporary directory and renamed into place one by one, so that commands
+// started meanwhile always find a complete shim.
+func (m *Manager) Rehash() ([]string, error) {
+	release, err := m.acquireLocks(shimsLock)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	gopExec, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	names, err := m.getShimNames()
+	if err != nil {
+		return nil, err
+	}
+
+	shimsDir := m.GetShimsDir()
+	tmpDir := fmt.Sprintf("%s.%d", shimsDir, os.Getpid())
+	if err := m.fs.RemoveAll(tmpDir); err != nil {
+		return nil, err
+	}
+	if err := m.fs.MkdirAll(tmpDir, 0755); err != nil {
+		return nil, err
+	}
+	defer m.fs.RemoveAll(tmpDir)
+	for _, name := range names {
+		shim := fmt.Sprintf("#!/bin/sh\n# generated by `gop rehash`, do not edit\nexec %s %s %s \"$@\"\n", shellQuote(gopExec), ShimCommand, shellQuote(name))
+		if err := m.fs.WriteFile(filepath.Join(tmpDir, name), []byte(shim), 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := m.fs.MkdirAll(shimsDir, 0755); err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		if err := m.fs.Rename(filepath.Join(tmpDir, name), filepath.Join(shimsDir, name)); err != nil {
+			return nil, err
+		}
+	}
+	// remove the shims of executables no version provides anymore
+	existing, err := m.fs.ReadDir(shimsDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range existing {
+		if !stringContains(names, f.Name()) {
+			if err := m.fs.Remove(filepath.Join(shimsDir, f.Name())); err != nil {
+				return nil, err
+			}
+		}
+	}
+	logger.Infof("wrote %d shims to %s", len(names), shimsDir)
+	return names, nil
+}
+
+// ShimCommand is the command shims run gop with, followed by the name of the shim and its arguments
+const ShimCommand = "shim-exec"
+
+// RunShim runs the command of a shim from the selected version, given the arguments after ShimCommand:
+// the name of the shim and its arguments. Unlike `gop exec`, it skips what the command line interface
+// does before each command (the configuration check, migrations, cleanups), which shims run far too
+// often for. Main packages call it before building the cli.App.
+func RunShim(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("no command given")
+	}
+	return defaultManager().ExecCommand(args[0], args[1:])
+}
+
+// rehashIfEnabled regenerates shims after versions are added or removed, if shims are in use
+func (m *Manager) rehashIfEnabled() error {
+	if _, err := m.fs.Stat(m.GetShimsDir()); err != nil {
+		return nil
+	}
+	_, err := m.Rehash()
+	return err
+}
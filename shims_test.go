@@ -0,0 +1,55 @@
+package pgo
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRehash(t *testing.T) {
+	prefix := t.TempDir()
+	installFakeVersion(t, prefix, "3.11.9")
+	installFakeVersion(t, prefix, "3.12.1")
+	black := filepath.Join(prefix, versionsPath, "3.12.1", "bin", "black")
+	if err := ioutil.WriteFile(black, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	m := NewManager(Config{PPrefix: prefix}, ManagerOptions{})
+
+	names, err := m.Rehash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"black", "python"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("Rehash = %v, want %v", names, want)
+	}
+	shim, err := ioutil.ReadFile(filepath.Join(m.GetShimsDir(), "black"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(shim), " "+ShimCommand+" 'black' ") {
+		t.Errorf("shim does not run %s:\n%s", ShimCommand, shim)
+	}
+
+	// shims of executables that are gone are removed, the others are replaced
+	if err := os.Remove(black); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Rehash(); err != nil {
+		t.Fatal(err)
+	}
+	files, err := ioutil.ReadDir(m.GetShimsDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Name() != "python" {
+		t.Errorf("shims after removing black: %v", files)
+	}
+	if _, err := os.Stat(fmt.Sprintf("%s.%d", m.GetShimsDir(), os.Getpid())); !os.IsNotExist(err) {
+		t.Errorf("temporary shims directory left behind: %v", err)
+	}
+}
@@ -0,0 +1,515 @@
+package pgo
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sigstoreIdentity is the signer of the releases of a minor line: the e-mail address of the release
+// manager in the signing certificate, and the OpenID Connect provider it was issued for
+type sigstoreIdentity struct {
+	email  string
+	issuer string
+}
+
+// sigstoreIdentities are the release managers signing each minor line with Sigstore,
+// from https://www.python.org/downloads/metadata/sigstore/
+var sigstoreIdentities = map[string]sigstoreIdentity{
+	"3.7":  {"nad@python.org", "https://github.com/login/oauth"},
+	"3.8":  {"lukasz@langa.pl", "https://github.com/login/oauth"},
+	"3.9":  {"lukasz@langa.pl", "https://github.com/login/oauth"},
+	"3.10": {"pablogsal@python.org", "https://accounts.google.com"},
+	"3.11": {"pablogsal@python.org", "https://accounts.google.com"},
+	"3.12": {"thomas@python.org", "https://accounts.google.com"},
+	"3.13": {"thomas@python.org", "https://accounts.google.com"},
+	"3.14": {"hugo@python.org", "https://github.com/login/oauth"},
+	"3.15": {"hugo@python.org", "https://github.com/login/oauth"},
+}
+
+// certificate extensions of Fulcio holding the OpenID Connect issuer: the deprecated one with the raw
+// string, and the one with a DER encoded UTF8String
+var (
+	oidFulcioIssuer   = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 1}
+	oidFulcioIssuerV2 = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 8}
+	oidSubjectAltName = asn1.ObjectIdentifier{2, 5, 29, 17}
+)
+
+// jsonInt64 is an integer of a protobuf JSON document, where 64-bit integers are usually quoted
+type jsonInt64 int64
+
+func (i *jsonInt64) UnmarshalJSON(data []byte) error {
+	n, err := strconv.ParseInt(strings.Trim(string(data), `"`), 10, 64)
+	if err != nil {
+		return err
+	}
+	*i = jsonInt64(n)
+	return nil
+}
+
+// validity is the period a key or certificate authority of a trusted root was used in; End is zero if it still is
+type validity struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+func (v validity) contains(t time.Time) bool {
+	return !t.Before(v.Start) && (v.End.IsZero() || !t.After(v.End))
+}
+
+// trustedRoot is a Sigstore trusted root document, of which the certificate authorities and
+// the transparency logs are used
+type trustedRoot struct {
+	Tlogs []struct {
+		PublicKey struct {
+			RawBytes []byte   `json:"rawBytes"`
+			ValidFor validity `json:"validFor"`
+		} `json:"publicKey"`
+		LogID struct {
+			KeyID []byte `json:"keyId"`
+		} `json:"logId"`
+	} `json:"tlogs"`
+	CertificateAuthorities []struct {
+		URI       string `json:"uri"`
+		CertChain struct {
+			Certificates []struct {
+				RawBytes []byte `json:"rawBytes"`
+			} `json:"certificates"`
+		} `json:"certChain"`
+		ValidFor validity `json:"validFor"`
+	} `json:"certificateAuthorities"`
+}
+
+func parseTrustedRoot(data []byte) (*trustedRoot, error) {
+	root := &trustedRoot{}
+	if err := json.Unmarshal(data, root); err != nil {
+		return nil, fmt.Errorf("invalid sigstore trusted root: %w", err)
+	}
+	return root, nil
+}
+
+// sigstoreBundle is a Sigstore bundle (versions 0.1 to 0.3) of a message signature
+type sigstoreBundle struct {
+	MediaType            string `json:"mediaType"`
+	VerificationMaterial struct {
+		X509CertificateChain *struct {
+			Certificates []struct {
+				RawBytes []byte `json:"rawBytes"`
+			} `json:"certificates"`
+		} `json:"x509CertificateChain"`
+		Certificate *struct {
+			RawBytes []byte `json:"rawBytes"`
+		} `json:"certificate"`
+		TlogEntries []tlogEntry `json:"tlogEntries"`
+	} `json:"verificationMaterial"`
+	MessageSignature *struct {
+		MessageDigest struct {
+			Algorithm string `json:"algorithm"`
+			Digest    []byte `json:"digest"`
+		} `json:"messageDigest"`
+		Signature []byte `json:"signature"`
+	} `json:"messageSignature"`
+}
+
+// tlogEntry is a transparency log entry of a Sigstore bundle, with the promise of the log to include it
+// (a signed entry timestamp), the proof that the log includes it, or both
+type tlogEntry struct {
+	LogIndex jsonInt64 `json:"logIndex"`
+	LogID    struct {
+		KeyID []byte `json:"keyId"`
+	} `json:"logId"`
+	KindVersion struct {
+		Kind string `json:"kind"`
+	} `json:"kindVersion"`
+	IntegratedTime   jsonInt64 `json:"integratedTime"`
+	InclusionPromise *struct {
+		SignedEntryTimestamp []byte `json:"signedEntryTimestamp"`
+	} `json:"inclusionPromise"`
+	InclusionProof *inclusionProof `json:"inclusionProof"`
+	// CanonicalizedBody is the entry as logged, its leaf in the Merkle tree of the log
+	CanonicalizedBody []byte `json:"canonicalizedBody"`
+}
+
+// inclusionProof is the path from an entry to the root of the Merkle tree of a transparency log (RFC 9162),
+// and the checkpoint in which the log signs that root
+type inclusionProof struct {
+	// LogIndex is the index of the entry in the tree, which differs from the index in the log once it is sharded
+	LogIndex   jsonInt64 `json:"logIndex"`
+	RootHash   []byte    `json:"rootHash"`
+	TreeSize   jsonInt64 `json:"treeSize"`
+	Hashes     [][]byte  `json:"hashes"`
+	Checkpoint struct {
+		Envelope string `json:"envelope"`
+	} `json:"checkpoint"`
+}
+
+// hashedRekord is the body of a transparency log entry of a signed digest
+type hashedRekord struct {
+	Kind string `json:"kind"`
+	Spec struct {
+		Data struct {
+			Hash struct {
+				Algorithm string `json:"algorithm"`
+				Value     string `json:"value"`
+			} `json:"hash"`
+		} `json:"data"`
+		Signature struct {
+			Content   []byte `json:"content"`
+			PublicKey struct {
+				Content []byte `json:"content"`
+			} `json:"publicKey"`
+		} `json:"signature"`
+	} `json:"spec"`
+}
+
+// rekorPayload is what the signed entry timestamp of a transparency log entry signs, in canonical JSON:
+// the fields are in lexical order and json.Marshal adds no white space
+type rekorPayload struct {
+	Body           string `json:"body"`
+	IntegratedTime int64  `json:"integratedTime"`
+	LogID          string `json:"logID"`
+	LogIndex       int64  `json:"logIndex"`
+}
+
+// verifyBundle checks that the bundle signs the given SHA-256 digest with a certificate issued by one of the
+// certificate authorities of the root, and that the signature was logged in one of its transparency logs
+// while the certificate was valid. It returns the signing certificate, whose identity is left to the caller.
+//
+// This covers the bundles of python.org releases, not the whole Sigstore specification: only message
+// signatures with ECDSA keys logged as hashedrekord entries are handled, and timestamps of timestamp
+// authorities are ignored. Every entry of the bundle has to verify, with a signed entry timestamp, an
+// inclusion proof in a checkpoint signed by the log, or both. Only the signed entry timestamp signs the
+// time of the entry, which the certificate is checked against; for an entry with an inclusion proof alone,
+// the time recorded in the bundle is used as is.
+func (r *trustedRoot) verifyBundle(data []byte, digest []byte) (*x509.Certificate, error) {
+	bundle := &sigstoreBundle{}
+	if err := json.Unmarshal(data, bundle); err != nil {
+		return nil, fmt.Errorf("invalid sigstore bundle: %w", err)
+	}
+	if !strings.HasPrefix(bundle.MediaType, "application/vnd.dev.sigstore.bundle") {
+		return nil, fmt.Errorf("invalid sigstore bundle: unknown media type %q", bundle.MediaType)
+	}
+	signature := bundle.MessageSignature
+	if signature == nil {
+		return nil, fmt.Errorf("sigstore bundle holds no message signature")
+	}
+	if signature.MessageDigest.Algorithm != "SHA2_256" || !bytes.Equal(signature.MessageDigest.Digest, digest) {
+		return nil, fmt.Errorf("%w: sigstore bundle signs another file", ErrVerificationFailed)
+	}
+
+	var certDER []byte
+	material := bundle.VerificationMaterial
+	if material.Certificate != nil {
+		certDER = material.Certificate.RawBytes
+	} else if material.X509CertificateChain != nil && len(material.X509CertificateChain.Certificates) > 0 {
+		certDER = material.X509CertificateChain.Certificates[0].RawBytes
+	} else {
+		return nil, fmt.Errorf("sigstore bundle holds no certificate")
+	}
+	cert, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate in sigstore bundle: %w", err)
+	}
+	publicKey, ok := cert.PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("unsupported key type %T in sigstore bundle", cert.PublicKey)
+	}
+	if !ecdsa.VerifyASN1(publicKey, digest, signature.Signature) {
+		return nil, fmt.Errorf("%w: bad sigstore signature", ErrVerificationFailed)
+	}
+
+	if len(material.TlogEntries) == 0 {
+		return nil, fmt.Errorf("sigstore bundle holds no transparency log entry")
+	}
+	for i := range material.TlogEntries {
+		entry := &material.TlogEntries[i]
+		if err := r.verifyTlogEntry(entry, digest, signature.Signature, certDER); err != nil {
+			return nil, err
+		}
+		if err := r.verifyCertificate(cert, time.Unix(int64(entry.IntegratedTime), 0)); err != nil {
+			return nil, err
+		}
+	}
+	return cert, nil
+}
+
+// verifyTlogEntry checks that the entry logs the signature of the digest with the certificate, and that
+// one of the transparency logs of the root promised to include it or proved that it does
+func (r *trustedRoot) verifyTlogEntry(entry *tlogEntry, digest []byte, signature []byte, certDER []byte) error {
+	if entry.KindVersion.Kind != "hashedrekord" {
+		return fmt.Errorf("unsupported transparency log entry of kind %q in sigstore bundle, only hashedrekord entries are checked", entry.KindVersion.Kind)
+	}
+	if entry.InclusionPromise == nil && entry.InclusionProof == nil {
+		return fmt.Errorf("%w: the transparency log entry of the sigstore bundle has neither a signed entry timestamp nor an inclusion proof", ErrVerificationFailed)
+	}
+	body := hashedRekord{}
+	if err := json.Unmarshal(entry.CanonicalizedBody, &body); err != nil {
+		return fmt.Errorf("invalid transparency log entry in sigstore bundle: %w", err)
+	}
+	loggedCert, _ := pem.Decode(body.Spec.Signature.PublicKey.Content)
+	if body.Kind != "hashedrekord" || body.Spec.Data.Hash.Algorithm != "sha256" ||
+		body.Spec.Data.Hash.Value != hex.EncodeToString(digest) ||
+		!bytes.Equal(body.Spec.Signature.Content, signature) ||
+		loggedCert == nil || !bytes.Equal(loggedCert.Bytes, certDER) {
+		return fmt.Errorf("%w: the transparency log entry of the sigstore bundle is for another signature", ErrVerificationFailed)
+	}
+
+	integratedTime := time.Unix(int64(entry.IntegratedTime), 0)
+	logKey, err := r.tlogKey(entry.LogID.KeyID, integratedTime)
+	if err != nil {
+		return err
+	}
+	if entry.InclusionPromise != nil {
+		payload, err := json.Marshal(rekorPayload{
+			Body:           base64.StdEncoding.EncodeToString(entry.CanonicalizedBody),
+			IntegratedTime: int64(entry.IntegratedTime),
+			LogID:          hex.EncodeToString(entry.LogID.KeyID),
+			LogIndex:       int64(entry.LogIndex),
+		})
+		if err != nil {
+			return err
+		}
+		hash := sha256.Sum256(payload)
+		if !ecdsa.VerifyASN1(logKey, hash[:], entry.InclusionPromise.SignedEntryTimestamp) {
+			return fmt.Errorf("%w: bad signed entry timestamp in sigstore bundle", ErrVerificationFailed)
+		}
+	}
+	if entry.InclusionProof != nil {
+		if err := verifyInclusionProof(entry.InclusionProof, entry.CanonicalizedBody, logKey, entry.LogID.KeyID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tlogKey returns the key of the transparency log of the root with the given ID, which has to be valid at the given time
+func (r *trustedRoot) tlogKey(logID []byte, at time.Time) (*ecdsa.PublicKey, error) {
+	for _, tlog := range r.Tlogs {
+		if !bytes.Equal(tlog.LogID.KeyID, logID) {
+			continue
+		}
+		if !tlog.PublicKey.ValidFor.contains(at) {
+			return nil, fmt.Errorf("%w: the transparency log key of the sigstore bundle was not valid at %s", ErrVerificationFailed, at.UTC())
+		}
+		key, err := x509.ParsePKIXPublicKey(tlog.PublicKey.RawBytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid transparency log key in sigstore trusted root: %w", err)
+		}
+		logKey, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("unsupported transparency log key type %T in sigstore trusted root", key)
+		}
+		return logKey, nil
+	}
+	return nil, fmt.Errorf("%w: sigstore bundle was logged in an unknown transparency log", ErrVerificationFailed)
+}
+
+// verifyInclusionProof checks that the leaf is in the tree whose root the log signed in the checkpoint of the proof
+func verifyInclusionProof(proof *inclusionProof, leaf []byte, logKey *ecdsa.PublicKey, logID []byte) error {
+	index, size := uint64(proof.LogIndex), uint64(proof.TreeSize)
+	if proof.LogIndex < 0 || proof.TreeSize <= 0 || index >= size {
+		return fmt.Errorf("%w: invalid inclusion proof in sigstore bundle", ErrVerificationFailed)
+	}
+	// RFC 9162, section 2.1.3.2
+	root := sha256.Sum256(append([]byte{0}, leaf...))
+	hash := root[:]
+	fn, sn := index, size-1
+	for _, sibling := range proof.Hashes {
+		if sn == 0 {
+			return fmt.Errorf("%w: invalid inclusion proof in sigstore bundle", ErrVerificationFailed)
+		}
+		if fn&1 == 1 || fn == sn {
+			hash = hashChildren(sibling, hash)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			hash = hashChildren(hash, sibling)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	if sn != 0 || !bytes.Equal(hash, proof.RootHash) {
+		return fmt.Errorf("%w: the inclusion proof of the sigstore bundle does not lead to its root hash", ErrVerificationFailed)
+	}
+	return verifyCheckpoint(proof, logKey, logID)
+}
+
+// hashChildren returns the hash of an inner node of a Merkle tree
+func hashChildren(left []byte, right []byte) []byte {
+	hash := sha256.Sum256(append(append([]byte{1}, left...), right...))
+	return hash[:]
+}
+
+// verifyCheckpoint checks that the checkpoint, a signed note of the origin of the log, the size of its tree
+// and the root hash, is for the tree of the proof and signed with the key of the log
+func verifyCheckpoint(proof *inclusionProof, logKey *ecdsa.PublicKey, logID []byte) error {
+	envelope := proof.Checkpoint.Envelope
+	split := strings.LastIndex(envelope, "\n\n")
+	if split < 0 {
+		return fmt.Errorf("%w: invalid checkpoint in sigstore bundle", ErrVerificationFailed)
+	}
+	note := envelope[:split+1]
+	lines := strings.Split(note, "\n")
+	if len(lines) < 4 {
+		return fmt.Errorf("%w: invalid checkpoint in sigstore bundle", ErrVerificationFailed)
+	}
+	size, err := strconv.ParseInt(lines[1], 10, 64)
+	rootHash, hashErr := base64.StdEncoding.DecodeString(lines[2])
+	if err != nil || hashErr != nil || size != int64(proof.TreeSize) || !bytes.Equal(rootHash, proof.RootHash) {
+		return fmt.Errorf("%w: the checkpoint of the sigstore bundle is for another tree", ErrVerificationFailed)
+	}
+
+	// signature lines are "— <name> <base64 of the key hint and the signature>", the hint being
+	// the first bytes of the log ID
+	hash := sha256.Sum256([]byte(note))
+	for _, line := range strings.Split(envelope[split+2:], "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 || fields[0] != "\u2014" {
+			continue
+		}
+		sig, err := base64.StdEncoding.DecodeString(fields[2])
+		if err != nil || len(sig) <= 4 || len(logID) < 4 || !bytes.Equal(sig[:4], logID[:4]) {
+			continue
+		}
+		if ecdsa.VerifyASN1(logKey, hash[:], sig[4:]) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: the checkpoint of the sigstore bundle is not signed by its transparency log", ErrVerificationFailed)
+}
+
+// verifyCertificate checks that the signing certificate chains up to a certificate authority of the root
+// that was valid at the time the signature was logged, and that the certificate itself was
+func (r *trustedRoot) verifyCertificate(cert *x509.Certificate, at time.Time) error {
+	// the subject alternative name is critical in Fulcio certificates, but may only hold names that
+	// x509 does not know (e.g. otherName); the identity is checked by the caller
+	unhandled := cert.UnhandledCriticalExtensions[:0]
+	for _, oid := range cert.UnhandledCriticalExtensions {
+		if !oid.Equal(oidSubjectAltName) {
+			unhandled = append(unhandled, oid)
+		}
+	}
+	cert.UnhandledCriticalExtensions = unhandled
+
+	var lastErr error
+	for _, ca := range r.CertificateAuthorities {
+		certs := ca.CertChain.Certificates
+		if len(certs) == 0 || !ca.ValidFor.contains(at) {
+			continue
+		}
+		roots := x509.NewCertPool()
+		intermediates := x509.NewCertPool()
+		for i, raw := range certs {
+			c, err := x509.ParseCertificate(raw.RawBytes)
+			if err != nil {
+				return fmt.Errorf("invalid certificate of %s in sigstore trusted root: %w", ca.URI, err)
+			}
+			if i == len(certs)-1 {
+				roots.AddCert(c)
+			} else {
+				intermediates.AddCert(c)
+			}
+		}
+		_, err := cert.Verify(x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: intermediates,
+			CurrentTime:   at,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+		})
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+	}
+	if lastErr == nil {
+		return fmt.Errorf("%w: no certificate authority of the sigstore trusted root was valid at %s", ErrVerificationFailed, at.UTC())
+	}
+	return fmt.Errorf("%w: untrusted sigstore certificate: %s", ErrVerificationFailed, lastErr)
+}
+
+// certificateIssuer returns the OpenID Connect issuer a Fulcio certificate was issued for
+func certificateIssuer(cert *x509.Certificate) string {
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(oidFulcioIssuerV2) {
+			var issuer string
+			if _, err := asn1.Unmarshal(ext.Value, &issuer); err == nil {
+				return issuer
+			}
+		}
+	}
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(oidFulcioIssuer) {
+			return string(ext.Value)
+		}
+	}
+	return ""
+}
+
+// checkIdentity checks that a signing certificate was issued to the given identity
+func checkIdentity(cert *x509.Certificate, identity sigstoreIdentity) error {
+	if issuer := certificateIssuer(cert); issuer != identity.issuer {
+		return fmt.Errorf("%w: sigstore certificate issued by %q, expected %q", ErrVerificationFailed, issuer, identity.issuer)
+	}
+	for _, email := range cert.EmailAddresses {
+		if email == identity.email {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: sigstore certificate issued to %s, expected %s", ErrVerificationFailed, strings.Join(cert.EmailAddresses, ", "), identity.email)
+}
+
+// sigstoreTrustedRoot returns the trusted root in P_SIGSTORE_ROOT, or the bundled public-good one
+func (m *Manager) sigstoreTrustedRoot() (*trustedRoot, error) {
+	if m.cfg.PSigstoreRoot == "" {
+		return parseTrustedRoot([]byte(publicGoodTrustedRoot))
+	}
+	data, err := m.fs.ReadFile(m.cfg.PSigstoreRoot)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read sigstore trusted root: %w", err)
+	}
+	return parseTrustedRoot(data)
+}
+
+// checkSigstoreBundle checks a downloaded installer against its sigstore bundle, which has to be signed
+// by the release manager of the version
+func (m *Manager) checkSigstoreBundle(installerFile string, versionStr string, bundle []byte) error {
+	pver, err := parsePyVersion(versionStr)
+	if err != nil {
+		return err
+	}
+	identity, ok := sigstoreIdentities[minorLine(pver)]
+	if !ok {
+		return fmt.Errorf("no known sigstore identity for the release manager of %s", minorLine(pver))
+	}
+	root, err := m.sigstoreTrustedRoot()
+	if err != nil {
+		return err
+	}
+	sum, err := m.sha256File(installerFile)
+	if err != nil {
+		return err
+	}
+	digest, _ := hex.DecodeString(sum)
+	cert, err := root.verifyBundle(bundle, digest)
+	if err == nil {
+		err = checkIdentity(cert, identity)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", filepath.Base(installerFile), err)
+	}
+	logger.Infof("%s signed by %s with sigstore", filepath.Base(installerFile), identity.email)
+	return nil
+}
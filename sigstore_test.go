@@ -0,0 +1,170 @@
+package pgo
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// the bundle in testdata signs this digest, and was made with the Sigstore scaffolding instance of sigstore-go's tests
+const scaffoldingDigest = "bc103b4a84971ef6459b294a2b98568a2bfb72cded09d4acd1e16366a401f95b"
+
+func readTestdata(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestVerifyBundle(t *testing.T) {
+	root, err := parseTrustedRoot(readTestdata(t, "sigstore-scaffolding-root.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundle := readTestdata(t, "othername.sigstore.json")
+	digest, _ := hex.DecodeString(scaffoldingDigest)
+
+	cert, err := root.verifyBundle(bundle, digest)
+	if err != nil {
+		t.Fatalf("verifyBundle: %s", err)
+	}
+	if issuer := certificateIssuer(cert); issuer != "http://oidc.local:8080" {
+		t.Errorf("issuer = %q", issuer)
+	}
+	if err := checkIdentity(cert, sigstoreIdentity{"foo@oidc.local", "http://oidc.local:8080"}); !errors.Is(err, ErrVerificationFailed) {
+		t.Errorf("checkIdentity with another e-mail address = %v, want ErrVerificationFailed", err)
+	}
+
+	// another file
+	other, _ := hex.DecodeString(scaffoldingDigest)
+	other[0] ^= 1
+	if _, err := root.verifyBundle(bundle, other); !errors.Is(err, ErrVerificationFailed) {
+		t.Errorf("verifyBundle of another digest = %v, want ErrVerificationFailed", err)
+	}
+
+	// a signed entry timestamp that does not match the entry
+	var doc map[string]interface{}
+	if err := json.Unmarshal(bundle, &doc); err != nil {
+		t.Fatal(err)
+	}
+	entry := doc["verificationMaterial"].(map[string]interface{})["tlogEntries"].([]interface{})[0].(map[string]interface{})
+	entry["integratedTime"] = "1720811190"
+	tampered, _ := json.Marshal(doc)
+	if _, err := root.verifyBundle(tampered, digest); !errors.Is(err, ErrVerificationFailed) {
+		t.Errorf("verifyBundle with a tampered entry = %v, want ErrVerificationFailed", err)
+	}
+
+	// an entry with an inclusion proof and no signed entry timestamp
+	entry["integratedTime"] = "1720811189"
+	promise := entry["inclusionPromise"]
+	delete(entry, "inclusionPromise")
+	proofOnly, _ := json.Marshal(doc)
+	if _, err := root.verifyBundle(proofOnly, digest); err != nil {
+		t.Errorf("verifyBundle with an inclusion proof only: %s", err)
+	}
+	proof := entry["inclusionProof"].(map[string]interface{})
+	hashes := proof["hashes"].([]interface{})
+	sibling := hashes[0]
+	hashes[0] = hashes[1]
+	tampered, _ = json.Marshal(doc)
+	if _, err := root.verifyBundle(tampered, digest); !errors.Is(err, ErrVerificationFailed) {
+		t.Errorf("verifyBundle with a tampered inclusion proof = %v, want ErrVerificationFailed", err)
+	}
+	hashes[0] = sibling
+	checkpoint := proof["checkpoint"].(map[string]interface{})
+	envelope := checkpoint["envelope"].(string)
+	checkpoint["envelope"] = strings.Replace(envelope, "\n4\n", "\n5\n", 1)
+	tampered, _ = json.Marshal(doc)
+	if _, err := root.verifyBundle(tampered, digest); !errors.Is(err, ErrVerificationFailed) {
+		t.Errorf("verifyBundle with a tampered checkpoint = %v, want ErrVerificationFailed", err)
+	}
+	checkpoint["envelope"] = envelope
+	delete(entry, "inclusionProof")
+	tampered, _ = json.Marshal(doc)
+	if _, err := root.verifyBundle(tampered, digest); !errors.Is(err, ErrVerificationFailed) {
+		t.Errorf("verifyBundle with neither a signed entry timestamp nor an inclusion proof = %v, want ErrVerificationFailed", err)
+	}
+
+	// every entry is checked, not only the first one
+	entry["inclusionPromise"] = promise
+	entry["inclusionProof"] = proof
+	bad := map[string]interface{}{}
+	for key, value := range entry {
+		bad[key] = value
+	}
+	bad["integratedTime"] = "1720811190"
+	delete(bad, "inclusionProof")
+	material := doc["verificationMaterial"].(map[string]interface{})
+	material["tlogEntries"] = []interface{}{entry, bad}
+	tampered, _ = json.Marshal(doc)
+	if _, err := root.verifyBundle(tampered, digest); !errors.Is(err, ErrVerificationFailed) {
+		t.Errorf("verifyBundle with a tampered second entry = %v, want ErrVerificationFailed", err)
+	}
+
+	// the scaffolding instance is not trusted by the public-good root
+	publicGood, err := parseTrustedRoot([]byte(publicGoodTrustedRoot))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := publicGood.verifyBundle(bundle, digest); !errors.Is(err, ErrVerificationFailed) {
+		t.Errorf("verifyBundle with the public-good root = %v, want ErrVerificationFailed", err)
+	}
+}
+
+// TestVerifyPythonOrgRelease downloads a release from python.org, which publishes no checksums,
+// and checks that it is verified with its sigstore bundle and the bundled trusted root
+func TestVerifyPythonOrgRelease(t *testing.T) {
+	if testing.Short() {
+		t.Skip("downloads a release from python.org")
+	}
+	const versionStr = "3.12.1"
+	installerURL := getPythonInstallerURLUnix(defaultMirror, versionStr)
+	client := &http.Client{Timeout: 5 * time.Minute}
+	resp, err := client.Get(installerURL)
+	if err != nil {
+		t.Skipf("python.org is not reachable: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET %s: %s", installerURL, resp.Status)
+	}
+
+	dir, err := ioutil.TempDir("", "gop-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	installer := filepath.Join(dir, filepath.Base(installerURL))
+	f, err := os.Create(installer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = io.Copy(f, resp.Body)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewManager(Config{PPrefix: dir}, ManagerOptions{HTTPClient: client})
+	if err := m.verifyPythonInstaller(installerURL, installer, versionStr, true); err != nil {
+		t.Fatalf("verifyPythonInstaller: %s", err)
+	}
+
+	// a corrupted download fails
+	if err := ioutil.WriteFile(installer, []byte("not a tarball"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.verifyPythonInstaller(installerURL, installer, versionStr, true); !errors.Is(err, ErrVerificationFailed) {
+		t.Errorf("verifyPythonInstaller of a corrupted download = %v, want ErrVerificationFailed", err)
+	}
+}
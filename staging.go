@@ -0,0 +1,69 @@
+package pgo
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// path after prefix where installations are built before being moved into place
+const stagingPath = "p/staging"
+
+// newStagingDir creates the directory a version is built in. It is named <version>.<pid>,
+// so directories left behind by interrupted installations can be told apart from running ones.
+func (m *Manager) newStagingDir(versionStr string) (string, error) {
+	stagingDir := filepath.Join(m.cfg.PPrefix, stagingPath, fmt.Sprintf("%s.%d", versionStr, os.Getpid()))
+	if err := m.fs.RemoveAll(stagingDir); err != nil {
+		return "", err
+	}
+	if err := m.fs.MkdirAll(stagingDir, 0755); err != nil {
+		return "", err
+	}
+	return stagingDir, nil
+}
+
+// isProcessRunning reports whether a process with the given pid exists
+func isProcessRunning(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	err = process.Signal(syscall.Signal(0))
+	return err == nil || os.IsPermission(err)
+}
+
+// CleanStaging removes the staging directories of interrupted installations from the prefix set by P_PREFIX
+func CleanStaging() error {
+	return defaultManager().CleanStaging()
+}
+
+// CleanStaging removes staging directories left behind by installations that are no longer running
+func (m *Manager) CleanStaging() error {
+	stagingRoot := filepath.Join(m.cfg.PPrefix, stagingPath)
+	entries, err := m.fs.ReadDir(stagingRoot)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		idx := strings.LastIndex(entry.Name(), ".")
+		if idx < 0 {
+			continue
+		}
+		pid, err := strconv.Atoi(entry.Name()[idx+1:])
+		if err != nil || (pid != os.Getpid() && isProcessRunning(pid)) {
+			continue
+		}
+		stale := filepath.Join(stagingRoot, entry.Name())
+		logger.Infof("removing stale staging directory %s", stale)
+		if err := m.fs.RemoveAll(stale); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package pgo
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// Status describes the python version selected for the working directory and how it is reached
+type Status struct {
+	// Version is the selected version, or "system"
+	Version string `json:"version"`
+	// SelectedBy is where the version was selected from, see SelectVersions
+	SelectedBy string `json:"selected_by"`
+	// Active is the globally activated version, empty if no version is active
+	Active string `json:"active_version,omitempty"`
+	// Executable is the path of the selected python
+	Executable string `json:"path"`
+	// Latest and Stable are the versions found in the cached version index of the mirrors,
+	// empty if nothing is cached: the mirrors are not contacted for them
+	Latest string `json:"latest,omitempty"`
+	Stable string `json:"stable,omitempty"`
+	// BinDir is the directory that has to be on PATH to run the selected version as `python`
+	BinDir string `json:"bin_dir"`
+	OnPath bool   `json:"on_path"`
+	// ShadowedBy is the python found on PATH before BinDir, empty if BinDir comes first
+	ShadowedBy string `json:"shadowed_by,omitempty"`
+}
+
+// isExecutableFile reports whether path is a regular file with an executable bit set
+func (m *Manager) isExecutableFile(path string) bool {
+	info, err := m.fs.Stat(path)
+	return err == nil && !info.IsDir() && info.Mode()&0111 != 0
+}
+
+// GetStatus describes the version selected for the working directory, installed in the prefix set by P_PREFIX
+func GetStatus() (*Status, error) {
+	return defaultManager().GetStatus()
+}
+
+// GetStatus returns the status of the python version selected for the working directory.
+// It does not download anything: run RefreshIndex first for up-to-date Latest and Stable versions.
+func (m *Manager) GetStatus() (*Status, error) {
+	versions, source, err := m.SelectVersions()
+	if err != nil {
+		return nil, err
+	}
+	status := &Status{Version: versions[0], SelectedBy: source}
+
+	if active, err := m.getActiveVersion(); err == nil {
+		status.Active = active
+	} else if !errors.Is(err, ErrNotActive) {
+		return nil, err
+	}
+
+	if status.Version == systemVersion {
+		status.Executable, _ = m.findSystemCommand(excName)
+	} else {
+		status.Executable = m.getVersionDirectories(status.Version).Executable
+	}
+
+	// looked up as in offline mode, so that the status never waits on the mirrors
+	cfg := m.cfg
+	cfg.POffline = true
+	cached := m.withConfig(cfg)
+	if latest, err := cached.GetLatestVersion(); err == nil {
+		status.Latest = latest
+	} else {
+		logger.Debugf("no cached latest version: %s", err)
+	}
+	if stable, err := cached.GetStableVersion(); err == nil {
+		status.Stable = stable
+	} else {
+		logger.Debugf("no cached stable version: %s", err)
+	}
+
+	// the global version is reached through the active bin directory or the shims,
+	// versions selected by GOP_VERSION or a .python-version file only through the shims
+	_, activeDirs := m.getActiveDirectories()
+	binDirs := []string{m.GetShimsDir()}
+	status.BinDir = binDirs[0]
+	if source == globalSource {
+		binDirs = append(binDirs, activeDirs.BinDir)
+		status.BinDir = activeDirs.BinDir
+	}
+
+	var shadowedBy string
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		if stringContains(binDirs, dir) {
+			status.BinDir = dir
+			status.OnPath = true
+			break
+		}
+		if shadowedBy != "" {
+			continue
+		}
+		for _, name := range []string{excName, excName + "3"} {
+			if candidate := filepath.Join(dir, name); m.isExecutableFile(candidate) {
+				shadowedBy = candidate
+				break
+			}
+		}
+	}
+	if status.OnPath {
+		status.ShadowedBy = shadowedBy
+	}
+	return status, nil
+}
@@ -0,0 +1,65 @@
+package pgo
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestGetStatusDoesNotContactMirrors(t *testing.T) {
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		http.NotFound(w, r)
+	}))
+	defer ts.Close()
+
+	prefix := t.TempDir()
+	installFakeVersion(t, prefix, "3.11.9")
+	cfg := Config{PPrefix: prefix, PMirrors: []string{ts.URL + "/"}, PReleaseStatusURL: ts.URL + "/release-status.json"}
+	m := NewManager(cfg, ManagerOptions{HTTPClient: ts.Client()})
+	if err := m.ActivatePythonVersion("3.11.9"); err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := m.GetStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.SelectedBy != globalSource || status.Latest != "" || status.Stable != "" {
+		t.Errorf("status without a cached index = %+v", status)
+	}
+
+	// an index cached long ago is used as is
+	versions := []pyVersion{}
+	for _, vstr := range []string{"3.11.9", "3.12.1"} {
+		pver, err := parsePyVersion(vstr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		versions = append(versions, pver)
+	}
+	if err := m.writeIndexCache(m.indexKey(), versions); err != nil {
+		t.Fatal(err)
+	}
+	if status, err = m.GetStatus(); err != nil {
+		t.Fatal(err)
+	}
+	if status.Latest != "3.12.1" {
+		t.Errorf("latest version from the cached index = %q, want 3.12.1", status.Latest)
+	}
+	if n := atomic.LoadInt32(&requests); n != 0 {
+		t.Errorf("GetStatus made %d request(s) to the mirror", n)
+	}
+
+	data, err := json.Marshal(StatusDocument{DocumentHeader: newDocumentHeader(kindStatus), Status: status})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"selected_by":"global default"`) {
+		t.Errorf("status document without selected_by: %s", data)
+	}
+}
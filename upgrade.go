@@ -0,0 +1,208 @@
+package pgo
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Upgrade is the newest patch release of a minor line, and the installed ones it supersedes
+type Upgrade struct {
+	// Line is the "X.Y" minor line
+	Line string
+	// From are the installed patch releases older than To, oldest first
+	From []string
+	// To is the newest patch release, installed or available on the mirror
+	To string
+	// Installed reports whether To is already installed
+	Installed bool
+}
+
+// UpgradeOptions provides a structure for parameters of an upgrade
+type UpgradeOptions struct {
+	// Install is used to install the new patch release
+	Install InstallOptions
+	// Prune removes the installed patch releases superseded by the new one
+	Prune bool
+	// UpdateLocal replaces the old version with the new one in the nearest .python-version file
+	UpdateLocal bool
+}
+
+// minorLine returns the "X.Y" minor line of a version
+func minorLine(pver pyVersion) string {
+	return fmt.Sprintf("%d.%d", pver.Major, pver.Minor)
+}
+
+// GetOutdated lists the installed versions matching spec that have a newer patch release on the mirrors
+func GetOutdated(spec string) ([]Upgrade, error) {
+	return defaultManager().GetOutdated(spec)
+}
+
+// GetOutdated returns the minor lines with installed versions that are superseded by a newer patch release,
+// installed or available. If spec is set, only installed versions matching it are considered.
+func (m *Manager) GetOutdated(spec string) ([]Upgrade, error) {
+	var constraints versionSpec
+	if spec != "" {
+		var err error
+		if constraints, err = parseVersionSpec(spec); err != nil {
+			return nil, err
+		}
+	}
+
+	installed, err := m.GetInstalledVersions()
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	// installed versions of each minor line
+	installedByLine := map[string][]pyVersion{}
+	lines := []string{}
+	for _, vstr := range installed {
+		pver, err := parsePyVersion(vstr)
+		if err != nil || (constraints != nil && !constraints.matches(pver)) {
+			continue
+		}
+		line := minorLine(pver)
+		if _, ok := installedByLine[line]; !ok {
+			lines = append(lines, line)
+		}
+		installedByLine[line] = append(installedByLine[line], pver)
+	}
+	if len(lines) == 0 {
+		if spec != "" {
+			return nil, &VersionNotFoundError{Spec: spec, Candidates: installed, Installed: true}
+		}
+		return []Upgrade{}, nil
+	}
+	sort.Slice(lines, func(i, j int) bool {
+		return installedByLine[lines[i]][0].Release().Compare(installedByLine[lines[j]][0].Release()) < 0
+	})
+
+	available, err := m.GetAvailableVersions()
+	if err != nil {
+		return nil, err
+	}
+	upgrades := []Upgrade{}
+	for _, line := range lines {
+		lineSpec, err := parseVersionSpec(line)
+		if err != nil {
+			return nil, err
+		}
+		candidates := append([]string{}, available...)
+		for _, pver := range installedByLine[line] {
+			candidates = append(candidates, pver.String())
+		}
+		newest, ok := lineSpec.newestMatching(candidates)
+		if !ok {
+			continue
+		}
+		to, err := parsePyVersion(newest)
+		if err != nil {
+			return nil, err
+		}
+
+		upgrade := Upgrade{Line: line, From: []string{}, To: newest}
+		versions := installedByLine[line]
+		sort.Slice(versions, func(i, j int) bool { return versions[i].Compare(versions[j]) < 0 })
+		for _, pver := range versions {
+			switch cmp := pver.Compare(to); {
+			case cmp < 0:
+				upgrade.From = append(upgrade.From, pver.String())
+			case cmp == 0:
+				upgrade.Installed = true
+			}
+		}
+		if len(upgrade.From) > 0 {
+			upgrades = append(upgrades, upgrade)
+		}
+	}
+	return upgrades, nil
+}
+
+// updateLocalVersionFile replaces the versions in from with to in a .python-version file,
+// keeping comments and layout. It reports whether the file listed any of them.
+func (m *Manager) updateLocalVersionFile(filename string, from []string, to string) (bool, error) {
+	data, err := m.fs.ReadFile(filename)
+	if err != nil {
+		return false, err
+	}
+	lines := strings.Split(string(data), "\n")
+	updated := false
+	for i, line := range lines {
+		comment := ""
+		if idx := strings.Index(line, "#"); idx >= 0 {
+			line, comment = line[:idx], line[idx:]
+		}
+		fields := strings.Fields(line)
+		changed := false
+		for j, field := range fields {
+			if stringContains(from, field) {
+				fields[j] = to
+				changed = true
+			}
+		}
+		if !changed {
+			continue
+		}
+		updated = true
+		lines[i] = strings.Join(fields, " ")
+		if comment != "" {
+			lines[i] += "  " + comment
+		}
+	}
+	if !updated {
+		return false, nil
+	}
+	return true, m.fs.WriteFile(filename, []byte(strings.Join(lines, "\n")), 0644)
+}
+
+// UpgradePythonVersion installs the newer release of an upgrade with the environment's configuration
+func UpgradePythonVersion(upgrade Upgrade, opts UpgradeOptions) error {
+	return defaultManager().UpgradePythonVersion(upgrade, opts)
+}
+
+// UpgradePythonVersion installs the newest patch release of an upgrade and moves the active version,
+// and optionally the nearest .python-version file, from the superseded ones to it
+func (m *Manager) UpgradePythonVersion(upgrade Upgrade, opts UpgradeOptions) error {
+	if !upgrade.Installed {
+		if err := m.InstallPythonVersionWithOptions(upgrade.To, opts.Install); err != nil {
+			return err
+		}
+	}
+
+	active, err := m.getActiveVersion()
+	if err != nil && !errors.Is(err, ErrNotActive) {
+		return err
+	}
+	if stringContains(upgrade.From, active) {
+		logger.Infof("version %s was active, activating %s", active, upgrade.To)
+		if err := m.ActivatePythonVersion(upgrade.To); err != nil {
+			return err
+		}
+	}
+
+	if opts.UpdateLocal {
+		_, filename, err := m.GetLocalVersion()
+		if err != nil && !errors.Is(err, ErrNoLocalVersion) {
+			return err
+		}
+		if filename != "" {
+			if updated, err := m.updateLocalVersionFile(filename, upgrade.From, upgrade.To); err != nil {
+				return err
+			} else if updated {
+				logger.Infof("replaced %s with %s in %s", strings.Join(upgrade.From, ", "), upgrade.To, filename)
+			}
+		}
+	}
+
+	if opts.Prune {
+		for _, vstr := range upgrade.From {
+			logger.Infof("removing superseded version %s", vstr)
+			if err := m.UninstallPythonVersion(vstr); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
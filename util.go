@@ -2,205 +2,395 @@ package pgo
 
 import (
 	"fmt"
-	"io"
 	"io/ioutil"
-	"net/http"
 	"os"
-	"os/exec"
 	"path"
 	"path/filepath"
+	"regexp"
 	"runtime"
-	"sort"
+	"strings"
+	"sync"
+)
 
-	"github.com/blang/semver"
-	"github.com/mholt/archiver"
+// release directories in the listing of a mirror, and the files and directories linked from a listing
+var (
+	reReleaseDir  = regexp.MustCompile(`^([0-9]+\.[0-9]+\.[0-9]+)/$`)
+	reListingLink = regexp.MustCompile(`href="([^"?#]+)"`)
 )
 
-func getPythonVersions(mirrorURL string) ([]semver.Version, error) {
-	// call the mirror
-	resp, err := http.Get(mirrorURL)
+// how many release directories of a mirror are listed at the same time
+const listingWorkers = 8
+
+// fetchListing returns the names linked from the directory listing at the URL
+func (m *Manager) fetchListing(listingURL string) ([]string, error) {
+	resp, err := m.httpGet(listingURL)
 	if err != nil {
 		return nil, err
 	}
-
-	// read the request body
 	defer resp.Body.Close()
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return nil, err
 	}
-	htmlStr := string(body)
+	names := []string{}
+	for _, match := range reListingLink.FindAllStringSubmatch(string(body), -1) {
+		names = append(names, match[1])
+	}
+	return names, nil
+}
+
+// releaseDirVersions returns the versions of the installers among the files of a release directory:
+// the release itself only if its own installer is there, and its pre-releases
+func releaseDirVersions(release pyVersion, files []string) []pyVersion {
+	versions := []pyVersion{}
+	for _, file := range files {
+		match := reInstallerFile.FindStringSubmatch(path.Base(file))
+		if match == nil {
+			continue
+		}
+		pver, err := parsePyVersion(match[1])
+		if err == nil && pver.Release().Compare(release) == 0 {
+			versions = append(versions, pver)
+		}
+	}
+	return versions
+}
+
+// getPythonVersions returns the versions a mirror has installers of. The top-level listing of a remote
+// mirror only has a directory per release, so the directory of each release is listed as well,
+// except for the releases whose final version is in known (the previous index): their directories
+// do not change anymore.
+func (m *Manager) getPythonVersions(mirrorURL string, known []pyVersion) ([]pyVersion, error) {
+	if mirrorDir, ok := localMirrorPath(mirrorURL); ok {
+		return m.listLocalVersions(mirrorDir)
+	}
+
+	names, err := m.fetchListing(mirrorURL)
+	if err != nil {
+		return nil, err
+	}
+	minVersion, err := parsePyVersion(minLegalVersion)
+	if err != nil {
+		return nil, err
+	}
+	final := map[string]bool{}
+	for _, pver := range known {
+		if !pver.IsPrerelease() {
+			final[pver.String()] = true
+		}
+	}
+
+	pyVers := newPyVersionOrderedSet()
+	releases := []pyVersion{}
+	for _, name := range names {
+		match := reReleaseDir.FindStringSubmatch(name)
+		if match == nil {
+			continue
+		}
+		release, err := parsePyVersion(match[1])
+		if err != nil || release.Compare(minVersion) < 0 {
+			continue
+		}
+		if !final[release.String()] {
+			releases = append(releases, release)
+			continue
+		}
+		for _, pver := range known {
+			if pver.Release().Compare(release) == 0 {
+				_ = pyVers.Add(pver.String())
+			}
+		}
+	}
+	logger.Debugf("listing %d release directories of %s", len(releases), redactURL(mirrorURL))
+
+	// share a client between the listings, which run a few at a time
+	client, err := m.httpClient()
+	if err != nil {
+		return nil, err
+	}
+	lister := *m
+	lister.client = client
+	type listing struct {
+		versions []pyVersion
+		err      error
+	}
+	listings := make([]listing, len(releases))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < listingWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				files, err := lister.fetchListing(mirrorURL + releases[i].String() + "/")
+				listings[i] = listing{releaseDirVersions(releases[i], files), err}
+			}
+		}()
+	}
+	for i := range releases {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
 
-	// parse the html
-	versions := reIdentifier.FindAllString(htmlStr, -1)
+	for i, l := range listings {
+		if l.err != nil {
+			return nil, fmt.Errorf("unable to list release %s: %w", releases[i], l.err)
+		}
+		for _, pver := range l.versions {
+			_ = pyVers.Add(pver.String())
+		}
+	}
+	return pyVers.AsSlice(), nil
+}
 
-	semVers := newSemverOrderedSet()
-	for _, vstr := range versions {
-		_ = semVers.Add(vstr)
+// getMirrorVersions returns the versions on the first of the mirrors that answers,
+// given the versions of the previous index
+func (m *Manager) getMirrorVersions(known []pyVersion) ([]pyVersion, error) {
+	mirrorErr := &mirrorError{what: "the version index"}
+	for _, mirror := range m.cfg.PMirrors {
+		versions, err := m.getPythonVersions(mirror, known)
+		if err == nil {
+			return versions, nil
+		}
+		if m.context().Err() != nil {
+			return nil, err
+		}
+		logger.Warningf("unable to get the version index from %s: %s", redactURL(mirror), err)
+		mirrorErr.add(redactURL(mirror), err)
 	}
-	return semVers.AsSlice(), nil
+	return nil, mirrorErr
 }
 
-func getPythonInstaller(mirrorURL string, versionStr string, targetDir string) (string, error) {
-	// TODO: check the hashes
-	installerURL := getPythonInstallerURLUnix(mirrorURL, versionStr)
+func getPythonInstallerURL(mirrorURL string, versionStr string) string {
 	if runtime.GOOS == "windows" {
-		installerURL = getPythonInstallerURLWin(mirrorURL, versionStr)
+		return getPythonInstallerURLWin(mirrorURL, versionStr)
 	}
-	filename := path.Base(installerURL)
+	return getPythonInstallerURLUnix(mirrorURL, versionStr)
+}
+
+// getPythonInstaller downloads the installer into targetDir from the first of the given URLs that works,
+// and returns the file and the URL it was downloaded from. An installer left in targetDir by an earlier
+// attempt is used if it was downloaded from one of the URLs, otherwise it is downloaded again.
+func (m *Manager) getPythonInstaller(installerURLs []string, targetDir string) (string, string, error) {
+	filename := path.Base(installerURLs[0])
 	targetFile := filepath.Join(targetDir, filename)
 
 	// if the file exists, we're done
-	if _, err := os.Stat(targetFile); err == nil {
-		logger.Infof("file exists at %s, using it...", targetFile)
-		return targetFile, nil
+	if _, err := m.fs.Stat(targetFile); err == nil {
+		if installerURL, ok := m.installerOrigin(targetFile, installerURLs); ok {
+			logger.Infof("file exists at %s, using it...", targetFile)
+			return targetFile, installerURL, nil
+		}
+		logger.Infof("%s was not downloaded from %s, downloading it again", targetFile, redactURL(installerURLs[0]))
+		if err := m.removeInstaller(targetFile); err != nil {
+			return "", "", err
+		}
 	}
 
-	// Create the file
-	out, err := os.Create(targetFile)
-	if err != nil {
-		return "", err
+	if m.cfg.POffline {
+		if local := localMirrors(installerURLs); len(local) > 0 {
+			installerURLs = local
+		} else {
+			return "", "", fmt.Errorf("%w: %s would have to be downloaded", ErrOffline, redactURL(installerURLs[0]))
+		}
 	}
-	defer out.Close()
-	logger.Infof("writing to %s", targetFile)
 
-	// Get the data
-	resp, err := http.Get(installerURL)
-	if err != nil {
-		return "", err
+	mirrorErr := &mirrorError{what: filename}
+	for _, installerURL := range installerURLs {
+		if err := m.downloadFile(installerURL, targetFile); err != nil {
+			if m.context().Err() != nil {
+				return "", "", err
+			}
+			logger.Warningf("unable to download %s: %s", redactURL(installerURL), err)
+			mirrorErr.add(redactURL(installerURL), err)
+			continue
+		}
+		logger.Debugf("got file from %s", redactURL(installerURL))
+		if err := m.fs.WriteFile(targetFile+originSuffix, []byte(redactURL(installerURL)+"\n"), 0644); err != nil {
+			return "", "", err
+		}
+		return targetFile, installerURL, nil
 	}
-	defer resp.Body.Close()
-	logger.Infof("got file from %s", installerURL)
+	return "", "", mirrorErr
+}
 
-	// Write the body to file
-	_, err = io.Copy(out, resp.Body)
+// installerOrigin returns which of the URLs a downloaded installer came from, as recorded next to it.
+// Only the redacted URL is recorded, so that mirror credentials are not written to the disk.
+func (m *Manager) installerOrigin(installerFile string, installerURLs []string) (string, bool) {
+	data, err := m.fs.ReadFile(installerFile + originSuffix)
 	if err != nil {
-		return "", err
+		return "", false
+	}
+	origin := strings.TrimSpace(string(data))
+	for _, installerURL := range installerURLs {
+		if redactURL(installerURL) == origin {
+			return installerURL, true
+		}
 	}
+	return "", false
+}
 
-	return targetFile, nil
+// removeInstaller removes a downloaded installer and the record of its origin
+func (m *Manager) removeInstaller(installerFile string) error {
+	if err := m.fs.Remove(installerFile + originSuffix); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return m.fs.Remove(installerFile)
 }
 
-func installPythonInstaller(installerFile string, versionDir string) (string, error) {
+// installPythonInstaller builds the installer in stagingDir for installation in versionDir,
+// and returns the directory the installation was staged in
+func (m *Manager) installPythonInstaller(installerFile string, versionDir string, stagingDir string, info BuildInfo) (string, error) {
 	if runtime.GOOS == "windows" {
-		return installPythonInstallerWin(installerFile, versionDir)
+		return m.installPythonInstallerWin(installerFile, versionDir)
+	}
+	return m.installPythonInstallerUnix(installerFile, versionDir, stagingDir, info)
+}
+
+// getReleaseDir returns the mirror directory holding a version's files.
+// Pre-releases live in the directory of the final release, e.g. 3.13.0rc2 is under 3.13.0/
+func getReleaseDir(versionStr string) string {
+	pver, err := parsePyVersion(versionStr)
+	if err != nil {
+		return versionStr
 	}
-	return installPythonInstallerUnix(installerFile, versionDir)
+	return pver.Release().String()
 }
 
 func getPythonInstallerURLUnix(mirrorURL string, versionStr string) string {
-	return fmt.Sprintf("%s%s/Python-%s.tgz", mirrorURL, versionStr, versionStr)
+	return fmt.Sprintf("%s%s/Python-%s.tgz", mirrorURL, getReleaseDir(versionStr), versionStr)
 }
 
-func installPythonInstallerUnix(installerFile string, versionDir string) (string, error) {
+func (m *Manager) installPythonInstallerUnix(installerFile string, versionDir string, stagingDir string, info BuildInfo) (string, error) {
 	// the installer file is a tgz archive, so we must extract and cleanup
-	if err := archiver.Unarchive(installerFile, versionDir); err != nil {
+	m.emit(Event{Type: EventExtracting, Message: installerFile})
+	if err := m.extractTarGz(installerFile, stagingDir); err != nil {
+		return "", err
+	}
+	if err := m.context().Err(); err != nil {
 		return "", err
 	}
 	installerExtension := filepath.Ext(installerFile)
 	installerFilename := filepath.Base(installerFile)
 	installerFilestem := installerFilename[0 : len(installerFilename)-len(installerExtension)]
-	extractedDir := filepath.Join(versionDir, installerFilestem)
-	srcDir := filepath.Join(versionDir, "src")
-	if err := os.Rename(extractedDir, srcDir); err != nil {
+	extractedDir := filepath.Join(stagingDir, installerFilestem)
+	srcDir := filepath.Join(stagingDir, "src")
+	if err := m.fs.Rename(extractedDir, srcDir); err != nil {
 		return "", err
 	}
 	logger.Debugf("extracted to %s", srcDir)
 
 	// now we configure and build
 
-	// ./configure --prefix="$dir"
-	logger.Infof("running `./configure --prefix=%s`", versionDir)
-	cmd := exec.Command("./configure", fmt.Sprintf("--prefix=%s", versionDir))
-	cmd.Dir = srcDir
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		logger.Debugf("./configure output: %s", out)
-		logger.Debugf("`./configure` error: %s", err)
-		return "", fmt.Errorf("unable to configure python source in %s", srcDir)
+	// ./configure --prefix="$dir" $GOP_CONFIGURE_OPTS
+	m.emit(Event{Type: EventConfiguring, Message: info.LogFile})
+	configureArgs := append([]string{fmt.Sprintf("--prefix=%s", versionDir)}, info.ConfigureOpts...)
+	if err := m.runBuildStep(info.LogFile, srcDir, info.env(), "./configure", configureArgs...); err != nil {
+		return "", fmt.Errorf("unable to configure python source in %s: %w", srcDir, err)
 	}
 
-	// make &> /dev/null
-	logger.Infof("running `make`")
-	cmd = exec.Command("make")
-	cmd.Dir = srcDir
-	out, err = cmd.CombinedOutput()
-	if err != nil {
-		logger.Debugf("`./make output`: %s", out)
-		logger.Debugf("`make` error: %s", err)
-		return "", fmt.Errorf("unable to make python source in %s", srcDir)
+	// make -j$(nproc) $GOP_MAKE_OPTS
+	m.emit(Event{Type: EventCompiling, Message: info.LogFile})
+	if err := m.runBuildStep(info.LogFile, srcDir, info.env(), "make", info.MakeOpts...); err != nil {
+		return "", fmt.Errorf("unable to make python source in %s: %w", srcDir, err)
 	}
 
-	// make install &> /dev/null
-	logger.Infof("running `make install`")
-	cmd = exec.Command("make", "install")
-	cmd.Dir = srcDir
-	out, err = cmd.CombinedOutput()
-	if err != nil {
-		logger.Debugf("`make install` output: %s", out)
-		logger.Debugf("`make install` error: %s", err)
-		return "", fmt.Errorf("unable to make install python source in %s", srcDir)
+	// make install DESTDIR="$staging/root", which keeps the prefix compiled into python
+	destDir := filepath.Join(stagingDir, "root")
+	m.emit(Event{Type: EventInstalling, Message: info.LogFile})
+	if err := m.runBuildStep(info.LogFile, srcDir, info.env(), "make", "install", "DESTDIR="+destDir); err != nil {
+		return "", fmt.Errorf("unable to make install python source in %s: %w", srcDir, err)
+	}
+
+	// cleanup src directory
+	if err := m.fs.RemoveAll(srcDir); err != nil {
+		return "", err
 	}
 
-	// make links
+	return filepath.Join(destDir, versionDir), nil
+}
+
+// makePythonLinks links python and pip to python3 and pip3 in the version's bin directory, if needed.
+// The links are relative, so the directory can be moved.
+func (m *Manager) makePythonLinks(versionDir string) (string, error) {
 	python3Path := filepath.Join(versionDir, "bin", "python3")
 	pythonPath := filepath.Join(versionDir, "bin", "python")
-	if _, err = os.Stat(python3Path); err == nil {
-		if err = os.Symlink(python3Path, pythonPath); err != nil {
-			return "", err
+	if _, err := m.fs.Stat(pythonPath); os.IsNotExist(err) {
+		if _, err = m.fs.Stat(python3Path); err == nil {
+			if err = m.fs.Symlink("python3", pythonPath); err != nil {
+				return "", err
+			}
 		}
 	}
 
-	pip3Path := filepath.Join(versionDir, "bin", "pip3")
 	pipPath := filepath.Join(versionDir, "bin", "pip")
-	if _, err = os.Stat(pipPath); os.IsNotExist(err) {
-		if err = os.Symlink(pip3Path, pipPath); err != nil {
+	if _, err := m.fs.Lstat(pipPath); os.IsNotExist(err) {
+		if err = m.fs.Symlink("pip3", pipPath); err != nil {
 			return "", err
 		}
 	}
+	return pythonPath, nil
+}
 
-	// cleanup src directory
-	if err := os.RemoveAll(srcDir); err != nil {
-		return "", err
+// stagedPythonEnv returns the environment to run a python that has not been moved into place yet,
+// so that builds with --enable-shared find their libpython
+func stagedPythonEnv(pythonPath string) []string {
+	libDir := filepath.Join(filepath.Dir(filepath.Dir(pythonPath)), "lib")
+	env := os.Environ()
+	for _, name := range []string{"LD_LIBRARY_PATH", "DYLD_LIBRARY_PATH"} {
+		value := libDir
+		if os.Getenv(name) != "" {
+			value += string(filepath.ListSeparator) + os.Getenv(name)
+		}
+		env = append(env, name+"="+value)
 	}
+	return env
+}
 
-	// try checking its version
-	vStr, err := getPythonBinVersion(pythonPath)
+// checkPythonBinVersion makes sure the installed python runs and reports the version it was installed as
+func (m *Manager) checkPythonBinVersion(pythonPath string, versionStr string) error {
+	// python 2 prints its version to stderr
+	out, err := m.output(Command{Name: pythonPath, Args: []string{"--version"}, Env: stagedPythonEnv(pythonPath)}, true)
 	if err != nil {
-		return "", err
-	} else if vStr != filepath.Base(versionDir) {
-		return "", fmt.Errorf("installed python version %s mismatches specified", vStr)
+		return fmt.Errorf("unable to run %s: %w", pythonPath, err)
 	}
-
-	return pythonPath, nil
+	vStr, err := cleanVersionString(string(out))
+	if err != nil {
+		return err
+	} else if vStr != versionStr {
+		return fmt.Errorf("installed python version %s mismatches specified", vStr)
+	}
+	return nil
 }
 
 func getPythonInstallerURLWin(mirrorURL string, versionStr string) string {
-	return fmt.Sprintf("%s%s/python-%s.amd64.msi", mirrorURL, versionStr, versionStr)
+	return fmt.Sprintf("%s%s/python-%s.amd64.msi", mirrorURL, getReleaseDir(versionStr), versionStr)
 }
 
-func installPythonInstallerWin(installerFile string, versionDir string) (string, error) {
+func (m *Manager) installPythonInstallerWin(installerFile string, versionDir string) (string, error) {
 	panic("not implemented")
-	return "", nil
 }
 
-func getPythonBinVersion(pythonExec string) (string, error) {
-	out, err := exec.Command(pythonExec, "--version").Output()
+func (m *Manager) getPythonBinVersion(pythonExec string) (string, error) {
+	// python 2 prints its version to stderr
+	out, err := m.output(Command{Name: pythonExec, Args: []string{"--version"}}, true)
 	if err != nil {
 		return "", err
 	}
 	return cleanVersionString(string(out))
 }
 
-func checkConfiguration(cfg Config) error {
-	_, dirs := getActiveDirectories()
+func (m *Manager) checkConfiguration() error {
+	_, dirs := m.getActiveDirectories()
 
 	// make sure the bin directory is on PATH
 	dirsOnPath := filepath.SplitList(os.Getenv("PATH"))
 	binDir := dirs.BinDir
+	shimsDir := filepath.Join(m.cfg.PPrefix, shimsPath)
 	isOnPath := false
 	for _, onPath := range dirsOnPath {
-		if onPath == binDir {
+		if onPath == binDir || onPath == shimsDir {
 			isOnPath = true
 		}
 	}
@@ -211,6 +401,11 @@ func checkConfiguration(cfg Config) error {
 	return nil
 }
 
+// shellQuote quotes s for use as a single word in a POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
 func stringContains(s []string, e string) bool {
 	for _, a := range s {
 		if a == e {
@@ -219,48 +414,3 @@ func stringContains(s []string, e string) bool {
 	}
 	return false
 }
-
-type semverOrderedSet struct {
-	versions []semver.Version
-	counts   map[string]bool
-}
-
-func newSemverOrderedSet() *semverOrderedSet {
-	return &semverOrderedSet{
-		versions: make([]semver.Version, 0),
-		counts:   make(map[string]bool),
-	}
-}
-
-func (sv *semverOrderedSet) Len() int {
-	return len(sv.versions)
-}
-
-func (sv *semverOrderedSet) Less(i, j int) bool {
-	return sv.versions[i].Compare(sv.versions[j]) <= -1
-}
-
-func (sv *semverOrderedSet) Swap(i, j int) {
-	sv.versions[i], sv.versions[j] = sv.versions[j], sv.versions[i]
-}
-
-func (sv *semverOrderedSet) Add(versionStr string) error {
-	sver, err := semver.Make(versionStr)
-	if err != nil {
-		return err
-	}
-	if _, ok := sv.counts[sver.String()]; !ok {
-		sv.counts[sver.String()] = true
-		sv.versions = append(sv.versions, sver)
-	}
-	return nil
-}
-
-func (sv *semverOrderedSet) AsSlice() []semver.Version {
-	sort.Sort(sv)
-	slice := make([]semver.Version, 0, len(sv.versions))
-	for _, sver := range sv.versions {
-		slice = append(slice, sver)
-	}
-	return slice
-}
@@ -0,0 +1,85 @@
+package pgo
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// listingServer serves directory listings laid out like python.org, and records the paths requested
+type listingServer struct {
+	dirs      map[string][]string
+	mu        sync.Mutex
+	requested []string
+}
+
+func (s *listingServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requested = append(s.requested, r.URL.Path)
+	s.mu.Unlock()
+	names, ok := s.dirs[r.URL.Path]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	fmt.Fprintln(w, `<html><body><a href="../">../</a>`)
+	for _, name := range names {
+		fmt.Fprintf(w, "<a href=\"%s\">%s</a>  08-Dec-2023 22:43  -\n", name, name)
+	}
+	fmt.Fprintln(w, `</body></html>`)
+}
+
+func versionStrings(versions []pyVersion) []string {
+	strs := []string{}
+	for _, pver := range versions {
+		strs = append(strs, pver.String())
+	}
+	return strs
+}
+
+func TestGetPythonVersions(t *testing.T) {
+	server := &listingServer{dirs: map[string][]string{
+		"/":        {"2.6.9/", "3.12.0/", "3.12.1/", "3.13.0/", "3.14.0/", "index.html"},
+		"/3.12.0/": {"Python-3.12.0rc3.tgz", "Python-3.12.0.tgz", "Python-3.12.0.tgz.sigstore"},
+		"/3.12.1/": {"Python-3.12.1.tgz", "python-3.12.1.amd64.msi"},
+		// only release candidates so far
+		"/3.13.0/": {"Python-3.13.0rc1.tgz", "Python-3.13.0rc2.tgz", "Python-3.13.0rc2.tgz.asc"},
+		// nothing released yet
+		"/3.14.0/": {},
+	}}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+	m := NewManager(Config{PPrefix: t.TempDir(), PMirrors: []string{ts.URL + "/"}}, ManagerOptions{HTTPClient: ts.Client()})
+
+	versions, err := m.getPythonVersions(ts.URL+"/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"3.12.0rc3", "3.12.0", "3.12.1", "3.13.0rc1", "3.13.0rc2"}
+	if got := versionStrings(versions); !reflect.DeepEqual(got, want) {
+		t.Errorf("getPythonVersions = %v, want %v", got, want)
+	}
+
+	// releases complete in the previous index are not listed again, the others are
+	server.requested = nil
+	versions, err = m.getPythonVersions(ts.URL+"/", versions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := versionStrings(versions); !reflect.DeepEqual(got, want) {
+		t.Errorf("getPythonVersions with the previous index = %v, want %v", got, want)
+	}
+	if got := strings.Join(server.requested, " "); strings.Contains(got, "3.12") || !strings.Contains(got, "/3.13.0/") {
+		t.Errorf("requested %s, want the listings of 3.13.0 and 3.14.0 only", got)
+	}
+
+	// a listing that fails fails the index, rather than losing versions
+	delete(server.dirs, "/3.14.0/")
+	if _, err := m.getPythonVersions(ts.URL+"/", nil); err == nil {
+		t.Error("getPythonVersions with a failing release listing succeeded")
+	}
+}
@@ -0,0 +1,176 @@
+package pgo
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/openpgp"
+)
+
+// errors of the verification of downloads, to be checked with errors.Is
+var (
+	// ErrNotVerified is wrapped when neither a checksum nor a signature was available
+	ErrNotVerified = fmt.Errorf("no checksum or signature could be checked (set P_CHECKSUMS or P_KEYRING, or pass --insecure-skip-verify)")
+	// ErrVerificationFailed is wrapped when the checksum or the signature of a download does not match it
+	ErrVerificationFailed = fmt.Errorf("verification failed")
+)
+
+// fetchURL returns the body of the given URL, or the contents of the file if it is a file:// URL or a local path
+func (m *Manager) fetchURL(location string) ([]byte, error) {
+	if localPath, ok := localMirrorPath(location); ok {
+		data, err := m.fs.ReadFile(localPath)
+		if err != nil {
+			return nil, &DownloadError{URL: location, Err: err}
+		}
+		return data, nil
+	}
+	if m.cfg.POffline {
+		return nil, fmt.Errorf("%w: %s would have to be downloaded", ErrOffline, location)
+	}
+	resp, err := m.httpGet(location)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return ioutil.ReadAll(resp.Body)
+}
+
+// parseChecksumManifest reads sha256sum-style lines ("<sha256>  <filename>" or "<sha256> *<filename>")
+func parseChecksumManifest(data []byte) map[string]string {
+	sums := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		filename := path.Base(strings.TrimPrefix(fields[len(fields)-1], "*"))
+		sums[filename] = strings.ToLower(fields[0])
+	}
+	return sums
+}
+
+func (m *Manager) sha256File(filename string) (string, error) {
+	f, err := m.fs.OpenFile(filename, os.O_RDONLY, 0)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (m *Manager) checkSHA256(installerFile string, expected string) error {
+	actual, err := m.sha256File(installerFile)
+	if err != nil {
+		return err
+	}
+	if actual != expected {
+		return fmt.Errorf("%w: checksum mismatch for %s: expected sha256 %s, got %s", ErrVerificationFailed, filepath.Base(installerFile), expected, actual)
+	}
+	logger.Infof("sha256 of %s matches %s", filepath.Base(installerFile), expected)
+	return nil
+}
+
+func (m *Manager) checkSignature(installerFile string, keyringFile string, signature []byte) error {
+	keyringData, err := m.fs.OpenFile(keyringFile, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer keyringData.Close()
+	keyring, err := openpgp.ReadArmoredKeyRing(keyringData)
+	if err != nil {
+		return fmt.Errorf("unable to read keyring %s: %w", keyringFile, err)
+	}
+
+	f, err := m.fs.OpenFile(installerFile, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	signer, err := openpgp.CheckArmoredDetachedSignature(keyring, f, bytes.NewReader(signature))
+	if err != nil {
+		return fmt.Errorf("%w: bad signature for %s: %s", ErrVerificationFailed, filepath.Base(installerFile), err)
+	}
+	for name := range signer.Identities {
+		logger.Infof("%s signed by %s", filepath.Base(installerFile), name)
+	}
+	return nil
+}
+
+// verifyPythonInstaller checks a downloaded installer against the checksum manifest
+// (or a .sha256 file next to it on the mirror) and, if signed is set, its .sigstore bundle
+// and its detached .asc signature. At least one of them has to be checked, and none of them may fail.
+func (m *Manager) verifyPythonInstaller(installerURL string, installerFile string, versionStr string, signed bool) error {
+	filename := filepath.Base(installerFile)
+	verified := false
+
+	if m.cfg.PChecksums != "" {
+		data, err := m.fetchURL(m.cfg.PChecksums)
+		if err != nil {
+			return fmt.Errorf("unable to read checksum manifest: %w", err)
+		}
+		expected, ok := parseChecksumManifest(data)[filename]
+		if !ok {
+			return fmt.Errorf("no checksum for %s in %s", filename, m.cfg.PChecksums)
+		}
+		if err := m.checkSHA256(installerFile, expected); err != nil {
+			return err
+		}
+		verified = true
+	} else if data, err := m.fetchURL(installerURL + ".sha256"); err == nil {
+		fields := strings.Fields(string(data))
+		if len(fields) == 0 {
+			return fmt.Errorf("empty checksum at %s.sha256", installerURL)
+		}
+		if err := m.checkSHA256(installerFile, strings.ToLower(fields[0])); err != nil {
+			return err
+		}
+		verified = true
+	} else {
+		logger.Debugf("no checksum available: %s", err)
+	}
+
+	if !signed {
+		logger.Debugf("%s is not signed, not checking signature", installerURL)
+	} else if bundle, err := m.fetchURL(installerURL + ".sigstore"); err != nil {
+		logger.Debugf("no sigstore bundle available: %s", err)
+	} else if err := m.checkSigstoreBundle(installerFile, versionStr, bundle); errors.Is(err, ErrVerificationFailed) {
+		return err
+	} else if err != nil {
+		logger.Warningf("unable to check the sigstore bundle of %s: %s", filename, err)
+	} else {
+		verified = true
+	}
+
+	if _, err := m.fs.Stat(m.cfg.PKeyring); signed && err == nil {
+		signature, err := m.fetchURL(installerURL + ".asc")
+		if err != nil {
+			return fmt.Errorf("unable to get signature for %s: %w", filename, err)
+		}
+		if err := m.checkSignature(installerFile, m.cfg.PKeyring, signature); err != nil {
+			return err
+		}
+		verified = true
+	} else if signed {
+		logger.Debugf("no keyring at %s, not checking signature", m.cfg.PKeyring)
+	}
+
+	if !verified {
+		return fmt.Errorf("unable to verify %s: %w", filename, ErrNotVerified)
+	}
+	return nil
+}
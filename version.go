@@ -0,0 +1,151 @@
+package pgo
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	// a PEP 440 release with an optional pre-release segment, e.g. 3.13.0, 3.13.0rc2, 3.14.0-alpha.1
+	rePyVersion = regexp.MustCompile(`(?i)^([0-9]+)\.([0-9]+)\.([0-9]+)(?:[-_.]?(a|alpha|b|beta|c|rc|pre|preview)[-_.]?([0-9]*))?$`)
+	// normalized pre-release kinds, in ascending order
+	preKindOrder = map[string]int{"a": 0, "b": 1, "rc": 2}
+)
+
+// pyVersion is a python release version, following the subset of PEP 440 used by CPython releases
+type pyVersion struct {
+	Major uint64
+	Minor uint64
+	Patch uint64
+	// PreKind is "a", "b" or "rc" for pre-releases, and empty for final releases
+	PreKind string
+	PreNum  uint64
+}
+
+func parsePyVersion(vstr string) (pyVersion, error) {
+	m := rePyVersion.FindStringSubmatch(strings.TrimSpace(vstr))
+	if m == nil {
+		return pyVersion{}, fmt.Errorf("invalid python version %q", vstr)
+	}
+	v := pyVersion{}
+	v.Major, _ = strconv.ParseUint(m[1], 10, 64)
+	v.Minor, _ = strconv.ParseUint(m[2], 10, 64)
+	v.Patch, _ = strconv.ParseUint(m[3], 10, 64)
+	switch strings.ToLower(m[4]) {
+	case "":
+	case "a", "alpha":
+		v.PreKind = "a"
+	case "b", "beta":
+		v.PreKind = "b"
+	default:
+		v.PreKind = "rc"
+	}
+	if m[5] != "" {
+		v.PreNum, _ = strconv.ParseUint(m[5], 10, 64)
+	}
+	return v, nil
+}
+
+// IsPrerelease reports whether the version is an alpha, beta or release candidate
+func (v pyVersion) IsPrerelease() bool {
+	return v.PreKind != ""
+}
+
+// Release returns the version without its pre-release segment
+func (v pyVersion) Release() pyVersion {
+	return pyVersion{Major: v.Major, Minor: v.Minor, Patch: v.Patch}
+}
+
+func (v pyVersion) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if v.IsPrerelease() {
+		s += fmt.Sprintf("%s%d", v.PreKind, v.PreNum)
+	}
+	return s
+}
+
+func compareUint(a, b uint64) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
+
+// Compare returns -1, 0 or 1 if v is older than, the same as, or newer than o.
+// Pre-releases sort before the final release they precede.
+func (v pyVersion) Compare(o pyVersion) int {
+	if c := compareUint(v.Major, o.Major); c != 0 {
+		return c
+	}
+	if c := compareUint(v.Minor, o.Minor); c != 0 {
+		return c
+	}
+	if c := compareUint(v.Patch, o.Patch); c != 0 {
+		return c
+	}
+	switch {
+	case !v.IsPrerelease() && !o.IsPrerelease():
+		return 0
+	case !v.IsPrerelease():
+		return 1
+	case !o.IsPrerelease():
+		return -1
+	}
+	if c := preKindOrder[v.PreKind] - preKindOrder[o.PreKind]; c != 0 {
+		if c < 0 {
+			return -1
+		}
+		return 1
+	}
+	return compareUint(v.PreNum, o.PreNum)
+}
+
+type pyVersionOrderedSet struct {
+	versions []pyVersion
+	counts   map[string]bool
+}
+
+func newPyVersionOrderedSet() *pyVersionOrderedSet {
+	return &pyVersionOrderedSet{
+		versions: make([]pyVersion, 0),
+		counts:   make(map[string]bool),
+	}
+}
+
+func (pv *pyVersionOrderedSet) Len() int {
+	return len(pv.versions)
+}
+
+func (pv *pyVersionOrderedSet) Less(i, j int) bool {
+	return pv.versions[i].Compare(pv.versions[j]) <= -1
+}
+
+func (pv *pyVersionOrderedSet) Swap(i, j int) {
+	pv.versions[i], pv.versions[j] = pv.versions[j], pv.versions[i]
+}
+
+func (pv *pyVersionOrderedSet) Add(versionStr string) error {
+	pver, err := parsePyVersion(versionStr)
+	if err != nil {
+		return err
+	}
+	if _, ok := pv.counts[pver.String()]; !ok {
+		pv.counts[pver.String()] = true
+		pv.versions = append(pv.versions, pver)
+	}
+	return nil
+}
+
+func (pv *pyVersionOrderedSet) AsSlice() []pyVersion {
+	sort.Sort(pv)
+	slice := make([]pyVersion, 0, len(pv.versions))
+	for _, pver := range pv.versions {
+		slice = append(slice, pver)
+	}
+	return slice
+}